@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+	"github.com/sirupsen/logrus"
+)
+
+// apiTarget mirrors the fields of the Prometheus /api/v1/targets API.
+type apiTarget struct {
+	Name               string    `json:"name"`
+	Host               string    `json:"host"`
+	Port               int       `json:"port"`
+	Health             string    `json:"health"`
+	LastScrape         time.Time `json:"lastScrape"`
+	LastScrapeDuration float64   `json:"lastScrapeDuration"`
+	LastError          string    `json:"lastError"`
+}
+
+type apiResponse struct {
+	Status string      `json:"status"`
+	Data   interface{} `json:"data"`
+}
+
+// targetsAPIHandler lists every target with the outcome of its last scrape,
+// including targets created by module probes.
+func targetsAPIHandler(targets []*Target, prober *Prober) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		all := append(append([]*Target{}, targets...), prober.Targets()...)
+		result := make([]apiTarget, 0, len(all))
+		for _, target := range all {
+			endpoint := target.activeEndpoint()
+			options := endpoint.client.Options()
+			status := endpoint.exporter.LastScrape()
+			health := "unknown"
+			if !status.Time.IsZero() {
+				health = "down"
+				if status.Up {
+					health = "up"
+				}
+			}
+			result = append(result, apiTarget{
+				Name:               target.Name,
+				Host:               options.Hostname,
+				Port:               options.Port,
+				Health:             health,
+				LastScrape:         status.Time,
+				LastScrapeDuration: status.Duration.Seconds(),
+				LastError:          strings.Join(status.Errors, "; "),
+			})
+		}
+		sort.SliceStable(result, func(i, j int) bool {
+			return result[i].Name < result[j].Name
+		})
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(apiResponse{
+			Status: "success",
+			Data: map[string]interface{}{
+				"targets": result,
+			},
+		})
+		if err != nil {
+			logrus.Errorf("Cannot write targets API response: %v", err)
+		}
+	})
+}
+
+// stderrHandler serves what each PCP command printed on stderr when it last
+// printed anything, where the pcp tools explain their failures.
+func stderrHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(apiResponse{
+		Status: "success",
+		Data: map[string]interface{}{
+			"commands": pgpool2.LastStderr(),
+		},
+	})
+	if err != nil {
+		logrus.Errorf("Cannot write stderr response: %v", err)
+	}
+}
+
+// readOnlyHandler rejects the requests to handler that are not GET or HEAD,
+// for -read-only.
+func readOnlyHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Read-only mode, changes are disabled", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// disabledHandler rejects every request, for endpoints -read-only turns off.
+func disabledHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Read-only mode, this endpoint is disabled", http.StatusForbidden)
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// backendAddress is where pcp_node_info reports a backend node to listen.
+type backendAddress struct {
+	host string
+	port int
+}
+
+// backendConnectionString returns the lib/pq connection string of a backend
+// node, which a host or port in the data source overrides.
+func backendConnectionString(dataSource string, backend backendAddress) (string, error) {
+	connection, err := sqlConnectionString(dataSource, backend.host)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("port=%d %s", backend.port, connection), nil
+}
+
+// newBackendConnector returns the connector to a backend node.
+func newBackendConnector(dataSource string, backend backendAddress) (driver.Connector, error) {
+	connection, err := backendConnectionString(dataSource, backend)
+	if err != nil {
+		return nil, err
+	}
+	return pq.NewConnector(connection)
+}
+
+// backendVersion returns the PostgreSQL version of a backend node, e.g. 14.5
+// of "14.5 (Debian 14.5-1.pgdg110+1)".
+func backendVersion(ctx context.Context, dataSource string, backend backendAddress) (string, error) {
+	connector, err := newBackendConnector(dataSource, backend)
+	if err != nil {
+		return "", err
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	var version string
+	if err := db.QueryRowContext(ctx, "SHOW server_version").Scan(&version); err != nil {
+		return "", err
+	}
+	if fields := strings.Fields(version); len(fields) != 0 {
+		version = fields[0]
+	}
+	return version, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+// backgroundGatherer collects the targets every interval and serves the last
+// snapshot, so scrapes never wait for PCP commands.
+type backgroundGatherer struct {
+	targets    []*Target
+	interval   time.Duration
+	timestamps bool
+
+	mutex          sync.Mutex
+	metricFamilies []*dto.MetricFamily
+	err            error
+}
+
+// newBackgroundGatherer creates a gatherer for the targets. With timestamps,
+// every sample carries the time its collection started.
+func newBackgroundGatherer(targets []*Target, interval time.Duration, timestamps bool) *backgroundGatherer {
+	return &backgroundGatherer{
+		targets:    targets,
+		interval:   interval,
+		timestamps: timestamps,
+	}
+}
+
+// Run collects the targets until the process exits.
+func (g *backgroundGatherer) Run() {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	for {
+		g.collect()
+		<-ticker.C
+	}
+}
+
+func (g *backgroundGatherer) collect() {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), g.interval)
+	defer cancel()
+	gatherer, err := targetsGatherer(ctx, g.targets)
+	var metricFamilies []*dto.MetricFamily
+	if err == nil {
+		metricFamilies, err = gatherer.Gather()
+	}
+	if err != nil {
+		logrus.Errorf("Background collection failed: %v", err)
+	}
+	if g.timestamps {
+		timestamp := start.UnixNano() / int64(time.Millisecond)
+		for _, mf := range metricFamilies {
+			for _, m := range mf.GetMetric() {
+				m.TimestampMs = proto.Int64(timestamp)
+			}
+		}
+	}
+	g.mutex.Lock()
+	g.metricFamilies = metricFamilies
+	g.err = err
+	g.mutex.Unlock()
+}
+
+// Gather returns a copy of the last snapshot, as the metric mappings modify
+// what they are given.
+func (g *backgroundGatherer) Gather() ([]*dto.MetricFamily, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	metricFamilies := make([]*dto.MetricFamily, 0, len(g.metricFamilies))
+	for _, mf := range g.metricFamilies {
+		metricFamilies = append(metricFamilies, proto.Clone(mf).(*dto.MetricFamily))
+	}
+	return metricFamilies, g.err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	camelCaseRegExp = regexp.MustCompile(`[a-z][A-Z]`)
+
+	// units that should be converted to the base units seconds and bytes
+	nonBaseUnits = map[string]string{
+		"milliseconds": "seconds",
+		"microseconds": "seconds",
+		"nanoseconds":  "seconds",
+		"minutes":      "seconds",
+		"hours":        "seconds",
+		"days":         "seconds",
+		"kilobytes":    "bytes",
+		"megabytes":    "bytes",
+		"gigabytes":    "bytes",
+	}
+
+	// legacy metric names exported in v0 compatibility mode
+	lintExceptions = map[string]bool{
+		"pgpool2_node_count":           true,
+		"pgpool2_node_info":            true,
+		"pgpool2_proc_count":           true,
+		"pgpool2_watchdog_nodes_total": true,
+	}
+)
+
+// LintProblem is a naming or metadata issue of a metric family, in the spirit
+// of `promtool check metrics`.
+type LintProblem struct {
+	Metric string
+	Text   string
+}
+
+func (p LintProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Metric, p.Text)
+}
+
+func lintMetricFamilies(metricFamilies []*dto.MetricFamily) []LintProblem {
+	var problems []LintProblem
+	for _, mf := range metricFamilies {
+		name := mf.GetName()
+		if lintExceptions[name] {
+			continue
+		}
+		report := func(text string) {
+			problems = append(problems, LintProblem{Metric: name, Text: text})
+		}
+		if len(strings.TrimSpace(mf.GetHelp())) == 0 {
+			report("no help text")
+		}
+		if camelCaseRegExp.MatchString(name) {
+			report("metric names should be written in 'snake_case' not 'camelCase'")
+		}
+		labelNames := make(map[string]bool)
+		for _, m := range mf.GetMetric() {
+			for _, label := range m.GetLabel() {
+				labelNames[label.GetName()] = true
+			}
+		}
+		for labelName := range labelNames {
+			if camelCaseRegExp.MatchString(labelName) {
+				report(fmt.Sprintf("label name %q should be written in 'snake_case' not 'camelCase'", labelName))
+			}
+		}
+		isCounter := mf.GetType() == dto.MetricType_COUNTER
+		if isCounter && !strings.HasSuffix(name, "_total") {
+			report(`counter metrics should have "_total" suffix`)
+		}
+		if !isCounter && strings.HasSuffix(name, "_total") {
+			report(`non-counter metrics should not have "_total" suffix`)
+		}
+		if mf.GetType() != dto.MetricType_HISTOGRAM && mf.GetType() != dto.MetricType_SUMMARY {
+			for _, suffix := range []string{"_count", "_sum", "_bucket"} {
+				if strings.HasSuffix(name, suffix) {
+					report(fmt.Sprintf("non-histogram and non-summary metrics should not have %q suffix", suffix))
+				}
+			}
+		}
+		for _, part := range strings.Split(name, "_") {
+			if base, ok := nonBaseUnits[part]; ok {
+				report(fmt.Sprintf("use base unit %q instead of %q", base, part))
+			}
+		}
+	}
+	return problems
+}
+
+// runCheck collects metrics once, reports scrape errors and lint problems and
+// returns the process exit code.
+func runCheck(targets []*Target, config *Config) int {
+	metricFamilies, err := gatherOnce(targets, config)
+	if err != nil {
+		logrus.Errorf("Gathering metrics failed: %v", err)
+		return 1
+	}
+	exitCode := 0
+	for _, mf := range metricFamilies {
+		if mf.GetName() != "pgpool2_last_scrape_error" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if m.GetGauge().GetValue() != 0 {
+				fmt.Println("collection from Pgpool2 failed, see the errors above")
+				exitCode = 1
+				break
+			}
+		}
+	}
+	problems := lintMetricFamilies(metricFamilies)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) != 0 {
+		exitCode = 1
+	}
+	if exitCode == 0 {
+		fmt.Printf("%d metric families checked, no problems found\n", len(metricFamilies))
+	}
+	return exitCode
+}
@@ -0,0 +1,78 @@
+// Package collector lets forks and embedders of the exporter add their own
+// collectors, e.g. a site-specific check, without changing the collection
+// loop. Register a factory from an init function:
+//
+//	func init() {
+//		collector.Register("site_check", func() (collector.Collector, error) {
+//			return &siteCheckCollector{}, nil
+//		})
+//	}
+//
+// Every exporter target gets its own instance of each registered collector,
+// which runs on every scrape after the built-in ones.
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector collects one group of metrics from a Pgpool2 instance.
+type Collector interface {
+	// Describe sends the descriptors of all metrics Update can send.
+	Describe(ch chan<- *prometheus.Desc)
+	// Update sends the current metrics. ctx is done when the scrape deadline
+	// is reached.
+	Update(ctx context.Context, client *pgpool2.Client, ch chan<- prometheus.Metric) error
+}
+
+// Factory creates the collector instance of one exporter target.
+type Factory func() (Collector, error)
+
+var (
+	factoriesMutex sync.Mutex
+	factories      = make(map[string]Factory)
+)
+
+// Register makes a collector available under name. It panics if the name is
+// already taken, as it is meant to be called from init functions.
+func Register(name string, factory Factory) {
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("collector %s is already registered", name))
+	}
+	factories[name] = factory
+}
+
+// Names returns the names of all registered collectors in sorted order.
+func Names() []string {
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates an instance of the collector registered under name.
+func New(name string) (Collector, error) {
+	factoriesMutex.Lock()
+	factory, ok := factories[name]
+	factoriesMutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("collector %s is not registered", name)
+	}
+	c, err := factory()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create collector %s: %v", name, err)
+	}
+	return c, nil
+}
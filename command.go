@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCommandTimeout        = 10 * time.Second
+	defaultCommandMaxOutputBytes = 1 << 20
+)
+
+// CommandConfig is an operator supplied script run on every scrape, which
+// prints metrics in the Prometheus text format on stdout.
+type CommandConfig struct {
+	Name           string        `yaml:"name"`
+	Command        string        `yaml:"command"`
+	Args           []string      `yaml:"args"`
+	Timeout        time.Duration `yaml:"timeout"`
+	MaxOutputBytes int64         `yaml:"max_output_bytes"`
+}
+
+// commandsGatherer runs all commands in parallel, bound to ctx. A failing
+// command only leaves out its own metrics and is reported in
+// pgpool2_command_success.
+func commandsGatherer(ctx context.Context, commands []CommandConfig) prometheus.Gatherer {
+	gatherers := make(parallelGatherers, 0, len(commands))
+	for _, command := range commands {
+		command := command
+		gatherers = append(gatherers, prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return gatherCommand(ctx, command), nil
+		}))
+	}
+	return gatherers
+}
+
+func gatherCommand(ctx context.Context, command CommandConfig) []*dto.MetricFamily {
+	start := time.Now()
+	metricFamilies, err := runCommand(ctx, command)
+	success := 1.0
+	if err != nil {
+		logrus.Warnf("Command %s failed: %v", command.Name, err)
+		metricFamilies = nil
+		success = 0
+	}
+	labels := []*dto.LabelPair{{Name: proto.String("command"), Value: proto.String(command.Name)}}
+	return append(metricFamilies,
+		gaugeFamily("pgpool2_command_success", "Whether the last run of the command succeeded.", labels, success),
+		gaugeFamily("pgpool2_command_duration_seconds", "Duration of the last run of the command.", labels, time.Since(start).Seconds()),
+	)
+}
+
+// runCommand runs the command and parses its output, which must not exceed
+// the output size cap.
+func runCommand(ctx context.Context, command CommandConfig) ([]*dto.MetricFamily, error) {
+	timeout := command.Timeout
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	maxOutputBytes := command.MaxOutputBytes
+	if maxOutputBytes <= 0 {
+		maxOutputBytes = defaultCommandMaxOutputBytes
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command.Command, command.Args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	defer pgpool2.TrackProcess(command.Command)()
+	stop := pgpool2.CloseOnDone(ctx, stdout)
+	var out bytes.Buffer
+	_, readErr := io.Copy(&out, io.LimitReader(stdout, maxOutputBytes+1))
+	stop()
+	if int64(out.Len()) > maxOutputBytes {
+		// stop the command instead of waiting for it to write everything
+		cancel()
+		cmd.Wait()
+		return nil, fmt.Errorf("output exceeds %d bytes", maxOutputBytes)
+	}
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("timed out: %v", ctx.Err())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	return parseTextMetrics(&out)
+}
+
+// parseTextMetrics parses metrics in the Prometheus text format, sorted by
+// name.
+func parseTextMetrics(r io.Reader) ([]*dto.MetricFamily, error) {
+	var parser expfmt.TextParser
+	parsed, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, err
+	}
+	metricFamilies := make([]*dto.MetricFamily, 0, len(parsed))
+	for _, mf := range parsed {
+		metricFamilies = append(metricFamilies, mf)
+	}
+	sort.Slice(metricFamilies, func(i, j int) bool {
+		return metricFamilies[i].GetName() < metricFamilies[j].GetName()
+	})
+	return metricFamilies, nil
+}
+
+func gaugeFamily(name, help string, labels []*dto.LabelPair, value float64) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name: proto.String(name),
+		Help: proto.String(help),
+		Type: dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{
+			Label: labels,
+			Gauge: &dto.Gauge{Value: proto.Float64(value)},
+		}},
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+	"github.com/prometheus/common/version"
+)
+
+const (
+	compatTimeout    = 30 * time.Second
+	compatReportName = "report.txt"
+)
+
+// compatCommand is a PCP command run by the compat command with the keys of
+// the "Key : value" lines its parser reads. Keys missing in the output mean
+// metrics that are missing for the pgpool version.
+type compatCommand struct {
+	name string
+	run  func(ctx context.Context, client *pgpool2.Client) error
+	keys []string
+}
+
+var compatCommands = []compatCommand{
+	{name: "pcp_proc_count", run: func(ctx context.Context, client *pgpool2.Client) error {
+		_, err := client.ExecProcCountContext(ctx)
+		return err
+	}},
+	{name: "pcp_proc_info", run: func(ctx context.Context, client *pgpool2.Client) error {
+		_, err := client.ExecProcInfoContext(ctx)
+		return err
+	}, keys: []string{"Database", "Username", "PID", "Connected", "Client connection time", "Client idle duration", "Status"}},
+	{name: "pcp_watchdog_info", run: func(ctx context.Context, client *pgpool2.Client) error {
+		_, err := client.ExecWatchdogInfoContext(ctx)
+		return err
+	}, keys: []string{"Total Nodes", "Remote Nodes", "Member Remote Nodes", "Alive Remote Nodes", "Nodes required for quorum", "Quorum state", "VIP up on local node", "Node Name", "Host Name", "Status", "Status Name", "Membership Status"}},
+	{name: "pcp_pool_status", run: func(ctx context.Context, client *pgpool2.Client) error {
+		_, err := client.ExecPoolStatusContext(ctx)
+		return err
+	}, keys: []string{"name", "value", "desc"}},
+}
+
+// compatResult is how well the exporter parsed the output of a PCP command.
+type compatResult struct {
+	command string
+	// status is full, partial or failed
+	status string
+	notes  []string
+}
+
+// captureExecutor keeps the output of the last PCP command it ran.
+type captureExecutor struct {
+	executor pgpool2.Executor
+	output   *bytes.Buffer
+}
+
+func (e captureExecutor) Exec(ctx context.Context, parse func(io.Reader) error, cmd string, args []string, env []string) error {
+	e.output.Reset()
+	return e.executor.Exec(ctx, func(r io.Reader) error {
+		return parse(io.TeeReader(r, e.output))
+	}, cmd, args, env)
+}
+
+// runCompat runs every PCP command the exporter uses, records their output in
+// dir and reports which parsers read it fully, partially or not at all. The
+// directory can be attached to a request for support of a pgpool version and
+// replayed with -pcp.replay-dir. It returns the process exit code.
+func runCompat(options pgpool2.Options, dir string, out io.Writer) int {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		fmt.Fprintf(out, "Cannot create %s: %v\n", dir, err)
+		return 1
+	}
+	output := &bytes.Buffer{}
+	executor, err := pcpExecutor(options.Hostname)
+	if err != nil {
+		fmt.Fprintf(out, "Cannot create the PCP client: %v\n", err)
+		return 1
+	}
+	client, err := pgpool2.New(
+		pgpool2.WithOptions(options),
+		pgpool2.WithExecutor(recordExecutor{executor: captureExecutor{executor: executor, output: output}, dir: dir}),
+	)
+	if err != nil {
+		fmt.Fprintf(out, "Cannot create the PCP client: %v\n", err)
+		return 1
+	}
+	defer client.Clean()
+
+	var results []compatResult
+	check := func(name string, keys []string, run func(ctx context.Context) error) {
+		ctx, cancel := context.WithTimeout(context.Background(), compatTimeout)
+		defer cancel()
+		err := run(ctx)
+		results = append(results, compatParse(name, keys, output.String(), err))
+	}
+
+	nodeCount := 0
+	check("pcp_node_count", nil, func(ctx context.Context) (err error) {
+		nodeCount, err = client.ExecNodeCountContext(ctx)
+		return err
+	})
+	for i := 0; i < nodeCount; i++ {
+		id := i
+		check(fmt.Sprintf("pcp_node_info %d", id), pgpool2.NodeInfoKeys(), func(ctx context.Context) error {
+			_, err := client.ExecNodeInfoContext(ctx, id)
+			return err
+		})
+	}
+	for _, command := range compatCommands {
+		command := command
+		check(command.name, command.keys, func(ctx context.Context) error {
+			return command.run(ctx, client)
+		})
+	}
+
+	var report bytes.Buffer
+	fmt.Fprintf(&report, "%s %s compatibility report for %s:%d\n\n", exporterName, version.Version, options.Hostname, options.Port)
+	exitCode := 0
+	for _, result := range results {
+		fmt.Fprintf(&report, "%-20s %s\n", result.command, result.status)
+		for _, note := range result.notes {
+			fmt.Fprintf(&report, "  %s\n", note)
+		}
+		if result.status != "full" {
+			exitCode = 1
+		}
+	}
+	fmt.Fprintf(&report, "\nThe raw output of every command is in %s, attach the directory to the request.\n", dir)
+	out.Write(report.Bytes())
+	if err := os.WriteFile(filepath.Join(dir, compatReportName), report.Bytes(), 0600); err != nil {
+		fmt.Fprintf(out, "Cannot write the report: %v\n", err)
+		return 1
+	}
+	return exitCode
+}
+
+// compatParse rates the output of a command by the error of its run and the
+// keys of its parser found in the output.
+func compatParse(command string, keys []string, output string, err error) compatResult {
+	result := compatResult{command: command, status: "full"}
+	if err != nil {
+		result.status = "failed"
+		result.notes = append(result.notes, err.Error())
+		return result
+	}
+	if len(keys) == 0 {
+		return result
+	}
+	found := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			found[strings.TrimSpace(line[:i])] = true
+		}
+	}
+	var missing []string
+	for _, key := range keys {
+		if !found[key] {
+			missing = append(missing, key)
+		}
+		delete(found, key)
+	}
+	if len(missing) != 0 {
+		result.status = "partial"
+		result.notes = append(result.notes, "missing: "+strings.Join(missing, ", "))
+	}
+	if len(found) != 0 {
+		unread := make([]string, 0, len(found))
+		for key := range found {
+			unread = append(unread, key)
+		}
+		sort.Strings(unread)
+		result.notes = append(result.notes, "not read: "+strings.Join(unread, ", "))
+	}
+	return result
+}
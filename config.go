@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the optional YAML configuration file given with -config.file.
+type Config struct {
+	MetricMappings []MetricMapping       `yaml:"metric_mappings"`
+	Targets        []TargetConfig        `yaml:"targets"`
+	AuthModules    map[string]AuthModule `yaml:"auth_modules"`
+	Commands       []CommandConfig       `yaml:"commands"`
+	NodeIDs        NodeIDList            `yaml:"node_ids"`
+	// CollectorWeights divide the scrape timeout between the collectors
+	CollectorWeights map[string]float64 `yaml:"collector_weights"`
+	// DatabaseConnectionLimits are the expected connection ceilings of the
+	// databases, exported for saturation alerts
+	DatabaseConnectionLimits map[string]int `yaml:"database_connection_limits"`
+	LabelHashing             *LabelHashing  `yaml:"label_hashing"`
+	// CollectorIntervals are minimum intervals between runs of collectors
+	CollectorIntervals map[string]time.Duration `yaml:"collector_intervals"`
+	// MaintenanceNodes are the backend node ids in planned maintenance
+	MaintenanceNodes NodeIDList `yaml:"maintenance_nodes"`
+	// NodeInfoOverrides are regexps replacing how fields of pcp_node_info
+	// are parsed, by field name
+	NodeInfoOverrides map[string]string `yaml:"node_info_overrides"`
+	// HealthWeights replace the default weights of the inputs of
+	// pgpool2_cluster_health
+	HealthWeights map[string]float64 `yaml:"health_weights"`
+	// HealthMaxReplicationLag is the replication delay up to which a standby
+	// counts as in sync for pgpool2_cluster_health
+	HealthMaxReplicationLag float64 `yaml:"health_max_replication_lag"`
+}
+
+// MetricMapping renames or drops one exported metric family and renames or
+// drops its labels before exposition.
+type MetricMapping struct {
+	Metric     string            `yaml:"metric"`
+	Drop       bool              `yaml:"drop"`
+	Rename     string            `yaml:"rename"`
+	Labels     map[string]string `yaml:"labels"`
+	DropLabels []string          `yaml:"drop_labels"`
+}
+
+// NodeIDList is a list of backend node ids, given as a YAML list or as a
+// string of ids and ranges like "0-2,5".
+type NodeIDList []int
+
+func (l *NodeIDList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var spec string
+	if err := unmarshal(&spec); err == nil {
+		ids, err := parseNodeIDs(spec)
+		if err != nil {
+			return err
+		}
+		*l = ids
+		return nil
+	}
+	var ids []int
+	if err := unmarshal(&ids); err != nil {
+		return err
+	}
+	*l = ids
+	return nil
+}
+
+func parseNodeIDs(spec string) ([]int, error) {
+	ids := []int{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid node id %q", part)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid node id range %q", part)
+			}
+		}
+		for id := first; id <= last; id++ {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (l NodeIDList) Validate() error {
+	if l != nil && len(l) == 0 {
+		return fmt.Errorf("node_ids must not be empty")
+	}
+	seen := make(map[int]bool)
+	for _, id := range l {
+		if id < 0 {
+			return fmt.Errorf("invalid node id %d", id)
+		}
+		if seen[id] {
+			return fmt.Errorf("node id %d is listed more than once", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// LoadConfig reads and validates the configuration file, which may be
+// encrypted with sops or age, with ageIdentity as the identity file age
+// decrypts with. An empty path returns the default configuration.
+func LoadConfig(path string, ageIdentity string) (*Config, error) {
+	config := &Config{}
+	if len(path) == 0 {
+		return config, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err = decryptConfig(path, content, ageIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt config file %s: %v", path, err)
+	}
+	if err := validateConfigSchema(content); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, fmt.Errorf("cannot parse config file %s: %v", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// compileNodeInfoOverrides compiles the regexps of node_info_overrides, which
+// must capture the value of the field in their first group.
+func compileNodeInfoOverrides(overrides map[string]string) (pgpool2.NodeInfoOverrides, error) {
+	if overrides == nil {
+		return nil, nil
+	}
+	knownFields := make(map[string]bool)
+	for _, name := range pgpool2.NodeInfoFields() {
+		knownFields[name] = true
+	}
+	compiled := make(pgpool2.NodeInfoOverrides, len(overrides))
+	for field, expr := range overrides {
+		if !knownFields[field] {
+			return nil, fmt.Errorf("node_info_overrides has unknown field %s, must be one of %s", field, strings.Join(pgpool2.NodeInfoFields(), ", "))
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp for node_info_overrides field %s: %v", field, err)
+		}
+		if re.NumSubexp() == 0 {
+			return nil, fmt.Errorf("regexp for node_info_overrides field %s has no capture group", field)
+		}
+		compiled[field] = re
+	}
+	return compiled, nil
+}
+
+func (c *Config) Validate() error {
+	if err := c.NodeIDs.Validate(); err != nil {
+		return err
+	}
+	knownCollectors := make(map[string]bool)
+	for _, name := range collectorNames() {
+		knownCollectors[name] = true
+	}
+	for name, weight := range c.CollectorWeights {
+		if !knownCollectors[name] {
+			return fmt.Errorf("collector_weights has unknown collector %s", name)
+		}
+		if weight <= 0 {
+			return fmt.Errorf("collector %s has invalid weight %v", name, weight)
+		}
+	}
+	for name, interval := range c.CollectorIntervals {
+		if !knownCollectors[name] {
+			return fmt.Errorf("collector_intervals has unknown collector %s", name)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("collector %s has invalid interval %s", name, interval)
+		}
+	}
+	if err := validateConnectionLimits(c.DatabaseConnectionLimits); err != nil {
+		return err
+	}
+	if err := validateHealthWeights(c.HealthWeights); err != nil {
+		return err
+	}
+	if c.HealthMaxReplicationLag < 0 {
+		return fmt.Errorf("invalid health_max_replication_lag %v", c.HealthMaxReplicationLag)
+	}
+	if _, err := compileNodeInfoOverrides(c.NodeInfoOverrides); err != nil {
+		return err
+	}
+	// an empty list is fine here, e.g. to clear the nodes of a target
+	if len(c.MaintenanceNodes) != 0 {
+		if err := c.MaintenanceNodes.Validate(); err != nil {
+			return fmt.Errorf("maintenance_nodes: %v", err)
+		}
+	}
+	if c.LabelHashing != nil && len(c.LabelHashing.Salt) == 0 {
+		return fmt.Errorf("label_hashing must have a salt")
+	}
+	targetNames := make(map[string]bool)
+	for _, target := range c.Targets {
+		if len(target.Name) == 0 {
+			return fmt.Errorf("every target must have a name")
+		}
+		if targetNames[target.Name] {
+			return fmt.Errorf("target %s is defined more than once", target.Name)
+		}
+		targetNames[target.Name] = true
+		if target.Port < 0 || target.Port > 65535 {
+			return fmt.Errorf("target %s has invalid port %d", target.Name, target.Port)
+		}
+		if len(target.Endpoints) != 0 && len(target.Host) != 0 {
+			return fmt.Errorf("target %s has both host and endpoints", target.Name)
+		}
+		if _, err := target.EndpointOptions(pgpool2.Options{}); err != nil {
+			return fmt.Errorf("target %s: %v", target.Name, err)
+		}
+		if err := target.NodeIDs.Validate(); err != nil {
+			return fmt.Errorf("target %s: %v", target.Name, err)
+		}
+		if len(target.ClusterMode) != 0 && !isClusterMode(target.ClusterMode) {
+			return fmt.Errorf("target %s has unknown cluster mode %s", target.Name, target.ClusterMode)
+		}
+		if err := validateConnectionLimits(target.DatabaseConnectionLimits); err != nil {
+			return fmt.Errorf("target %s: %v", target.Name, err)
+		}
+		if len(target.Timezone) != 0 {
+			if _, err := time.LoadLocation(target.Timezone); err != nil {
+				return fmt.Errorf("target %s has unknown time zone %s", target.Name, target.Timezone)
+			}
+		}
+		if len(target.MaintenanceNodes) != 0 {
+			if err := target.MaintenanceNodes.Validate(); err != nil {
+				return fmt.Errorf("target %s: maintenance_nodes: %v", target.Name, err)
+			}
+		}
+		if _, err := compileNodeInfoOverrides(target.NodeInfoOverrides); err != nil {
+			return fmt.Errorf("target %s: %v", target.Name, err)
+		}
+		if len(target.Version) != 0 {
+			if _, err := pgpool2.ParseVersionNumber(target.Version); err != nil {
+				return fmt.Errorf("target %s: %v", target.Name, err)
+			}
+		}
+	}
+	for name, module := range c.AuthModules {
+		if err := module.Validate(); err != nil {
+			return fmt.Errorf("auth module %s: %v", name, err)
+		}
+	}
+	commandNames := make(map[string]bool)
+	for _, command := range c.Commands {
+		if len(command.Name) == 0 {
+			return fmt.Errorf("every command must have a name")
+		}
+		if commandNames[command.Name] {
+			return fmt.Errorf("command %s is defined more than once", command.Name)
+		}
+		commandNames[command.Name] = true
+		if len(command.Command) == 0 {
+			return fmt.Errorf("command %s has no command to run", command.Name)
+		}
+		if command.Timeout < 0 {
+			return fmt.Errorf("command %s has negative timeout %s", command.Name, command.Timeout)
+		}
+		if command.MaxOutputBytes < 0 {
+			return fmt.Errorf("command %s has negative max_output_bytes %d", command.Name, command.MaxOutputBytes)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, mapping := range c.MetricMappings {
+		if !model.IsValidMetricName(model.LabelValue(mapping.Metric)) {
+			return fmt.Errorf("metric mapping has invalid metric name %q", mapping.Metric)
+		}
+		if seen[mapping.Metric] {
+			return fmt.Errorf("metric %s is mapped more than once", mapping.Metric)
+		}
+		seen[mapping.Metric] = true
+		if len(mapping.Rename) != 0 && !model.IsValidMetricName(model.LabelValue(mapping.Rename)) {
+			return fmt.Errorf("metric %s is renamed to invalid name %q", mapping.Metric, mapping.Rename)
+		}
+		for from, to := range mapping.Labels {
+			if !model.LabelName(to).IsValid() {
+				return fmt.Errorf("label %s of metric %s is renamed to invalid name %q", from, mapping.Metric, to)
+			}
+		}
+	}
+	return nil
+}
+
+func validateConnectionLimits(limits map[string]int) error {
+	for database, limit := range limits {
+		if limit <= 0 {
+			return fmt.Errorf("database %s has invalid connection limit %d", database, limit)
+		}
+	}
+	return nil
+}
+
+// decryptConfig returns the content of a config file encrypted with sops or
+// age decrypted by their command line tools, which only write it to the pipe
+// they share with the exporter, and any other content unchanged. sops finds
+// its keys itself, e.g. in SOPS_AGE_KEY or SOPS_AGE_KEY_FILE.
+func decryptConfig(path string, content []byte, ageIdentity string) ([]byte, error) {
+	var cmd *exec.Cmd
+	switch {
+	case bytes.HasPrefix(content, []byte("age-encryption.org/")) || bytes.HasPrefix(content, []byte("-----BEGIN AGE ENCRYPTED FILE-----")):
+		if len(ageIdentity) == 0 {
+			return nil, fmt.Errorf("the file is encrypted with age, but -config.age-identity is not set")
+		}
+		cmd = exec.Command("age", "--decrypt", "--identity", ageIdentity, path)
+	case isSopsFile(content):
+		cmd = exec.Command("sops", "--decrypt", "--input-type", "yaml", "--output-type", "yaml", path)
+	default:
+		return content, nil
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	decrypted, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", cmd.Args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return decrypted, nil
+}
+
+// isSopsFile tells whether content is YAML with the metadata sops adds to the
+// files it encrypts.
+func isSopsFile(content []byte) bool {
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return false
+	}
+	_, ok := document["sops"]
+	return ok
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseNodeIDs(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []int
+		invalid bool
+	}{
+		{spec: "0", want: []int{0}},
+		{spec: "0-2,5", want: []int{0, 1, 2, 5}},
+		{spec: " 1 - 3 , 7 ", want: []int{1, 2, 3, 7}},
+		{spec: "4-4", want: []int{4}},
+		{spec: "", invalid: true},
+		{spec: "a", invalid: true},
+		{spec: "3-1", invalid: true},
+		{spec: "-1", invalid: true},
+		{spec: "1,,2", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseNodeIDs(tt.spec)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			config: `targets:
+  - name: a
+    host: pgpool-a
+    port: 9898
+  - name: b
+    endpoints: ["pgpool-b1", "pgpool-b2:9999"]
+node_ids: "0-2"
+collector_intervals:
+  watchdog: 1m
+auth_modules:
+  prod:
+    username: pcpadmin
+    hosts: [pgpool-c]
+`,
+		},
+		{
+			name:    "wrong type",
+			config:  "targets:\n  - name: a\n    port: high\n",
+			wantErr: "line 3: targets[0].port: expected an integer",
+		},
+		{
+			name:    "unknown key",
+			config:  "targets:\n  - name: a\n    hots: pgpool-a\n",
+			wantErr: "line 3: targets[0].hots: unknown key",
+		},
+		{
+			name:    "duration without unit",
+			config:  "collector_intervals:\n  watchdog: 60\n",
+			wantErr: "line 2: collector_intervals.watchdog: expected Go duration",
+		},
+		{
+			name:    "unknown collector",
+			config:  "collector_weights:\n  nodes: 2\n",
+			wantErr: "collector_weights has unknown collector nodes",
+		},
+		{
+			name:    "duplicate target",
+			config:  "targets:\n  - name: a\n  - name: a\n",
+			wantErr: "target a is defined more than once",
+		},
+		{
+			name:    "host and endpoints",
+			config:  "targets:\n  - name: a\n    host: pgpool-a\n    endpoints: [pgpool-b]\n",
+			wantErr: "target a has both host and endpoints",
+		},
+		{
+			name:    "invalid port",
+			config:  "targets:\n  - name: a\n    port: 70000\n",
+			wantErr: "target a has invalid port 70000",
+		},
+		{
+			name:    "duplicate node id",
+			config:  "node_ids: [1, 1]\n",
+			wantErr: "node id 1 is listed more than once",
+		},
+		{
+			name:    "empty node ids",
+			config:  "node_ids: []\n",
+			wantErr: "node_ids must not be empty",
+		},
+		{
+			name:    "auth module without hosts",
+			config:  "auth_modules:\n  prod:\n    username: pcpadmin\n",
+			wantErr: "auth module prod: no hosts",
+		},
+		{
+			name:    "label hashing without salt",
+			config:  "label_hashing:\n  labels: [username]\n",
+			wantErr: "label_hashing must have a salt",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yml")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadConfig(path, "")
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,4 @@
+#!/bin/sh
+# Lets the standby clone the primary and stream from it.
+set -e
+echo "host replication all all scram-sha-256" >> "$PGDATA/pg_hba.conf"
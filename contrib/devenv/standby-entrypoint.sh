@@ -0,0 +1,12 @@
+#!/bin/sh
+# Clones the primary on the first start and runs as its standby.
+set -e
+until pg_isready -h primary -U postgres; do
+    sleep 1
+done
+if [ ! -s "$PGDATA/PG_VERSION" ]; then
+    # the application name shows the replication state in pcp_node_info
+    pg_basebackup -d "host=primary user=postgres application_name=standby" -D "$PGDATA" -R -X stream
+    chmod 700 "$PGDATA"
+fi
+exec postgres
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const maskedSecret = "<secret>"
+
+// secretFlags are masked in the effective configuration.
+var secretFlags = map[string]bool{
+	"pcp.password": true,
+	"sql.dsn":      true,
+	"backend.dsn":  true,
+}
+
+// flagEnvDefaults are the environment variables a flag defaults to if it is
+// not set.
+var flagEnvDefaults = map[string]string{
+	"runtime.memory-limit": "GOMEMLIMIT",
+	"runtime.gogc":         "GOGC",
+}
+
+// printEffectiveConfig writes every flag with its value and where the value
+// comes from (flag, env or default), and the configuration file as it was
+// read, with secrets masked.
+func printEffectiveConfig(w io.Writer, config *Config) error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	fmt.Fprintln(w, "# flags")
+	flag.VisitAll(func(f *flag.Flag) {
+		value, source := f.Value.String(), "default"
+		if set[f.Name] {
+			source = "flag"
+		} else if env, ok := flagEnvDefaults[f.Name]; ok {
+			if envValue, ok := os.LookupEnv(env); ok {
+				value, source = envValue, "env "+env
+			}
+		}
+		if secretFlags[f.Name] {
+			value = maskSecret(value)
+		}
+		fmt.Fprintf(w, "%s=%q # %s\n", f.Name, value, source)
+	})
+	if len(*configFile) == 0 {
+		return nil
+	}
+	content, err := yaml.Marshal(maskConfigSecrets(config))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n# file %s\n%s", *configFile, content)
+	return nil
+}
+
+// maskSecret masks a secret, but not a reference to a Docker secret.
+func maskSecret(value string) string {
+	if len(value) == 0 || strings.HasPrefix(value, dockerSecretScheme) {
+		return value
+	}
+	return maskedSecret
+}
+
+// maskConfigSecrets returns a copy of config with the passwords and the label
+// hashing salt masked.
+func maskConfigSecrets(config *Config) *Config {
+	masked := *config
+	masked.Targets = make([]TargetConfig, len(config.Targets))
+	for i, target := range config.Targets {
+		target.Password = maskSecret(target.Password)
+		masked.Targets[i] = target
+	}
+	if config.AuthModules != nil {
+		masked.AuthModules = make(map[string]AuthModule, len(config.AuthModules))
+		for name, module := range config.AuthModules {
+			module.Password = maskSecret(module.Password)
+			masked.AuthModules[name] = module
+		}
+	}
+	if config.LabelHashing != nil {
+		hashing := *config.LabelHashing
+		hashing.Salt = maskSecret(hashing.Salt)
+		masked.LabelHashing = &hashing
+	}
+	return &masked
+}
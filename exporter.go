@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"fmt"
 
+	"github.com/navcanada/pgpool2-exporter/collector"
 	"github.com/navcanada/pgpool2-exporter/pgpool2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/version"
@@ -15,9 +22,54 @@ import (
 const (
 	namespace    = "pgpool2"
 	exporterName = "pgpool2_exporter"
+
+	// MetricsCompatV0 also exports the metric names used before the naming cleanup
+	MetricsCompatV0   = "v0"
+	MetricsCompatNone = "none"
+
+	// ClusterModeAuto detects the clustering mode with pcp_pool_status
+	ClusterModeAuto = "auto"
+	// clusterModeTTL is how long a detected clustering mode is used, it only
+	// changes with a restart of pgpool
+	clusterModeTTL = 10 * time.Minute
+	// versionTTL is how long a detected pgpool version is used, it only
+	// changes with an upgrade
+	versionTTL = 10 * time.Minute
+
+	// FailedCollectorsLast runs the collectors that failed in the last scrape
+	// after the others
+	FailedCollectorsLast  = "last"
+	FailedCollectorsFirst = "first"
+	FailedCollectorsKeep  = "none"
+
+	// NodeDetailBasic exports the node count and status only, without
+	// labels that need more than pcp_node_info
+	NodeDetailBasic = "basic"
+	// NodeDetailStandard adds weight, role and last status change
+	NodeDetailStandard = "standard"
+	// NodeDetailFull adds the replication labels, which need the cluster
+	// mode from pcp_pool_status, and DNS lookups
+	NodeDetailFull = "full"
+
+	// VersionSourcePCPTools is a version detected with --version of the
+	// local pcp tools, which need not be the version of the pgpool scraped
+	VersionSourcePCPTools = "pcp_tools"
+	// VersionSourcePgpool is a version reported by the pgpool scraped
+	VersionSourcePgpool = "pgpool"
+	// VersionSourceConfig is the version of a target in the config file
+	VersionSourceConfig = "config"
+
+	// defaultVIPPort is the default pgpool port, probed through the
+	// delegate IP
+	defaultVIPPort = "9999"
 )
 
 var (
+	PoolUp = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "up"),
+		"Whether Pgpool2 answered over PCP in the last scrape (1 if at least one PCP command succeeded)",
+		nil, nil,
+	)
 	PoolLastScrapeError = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "last_scrape_error"),
 		"Whether the last scrape of metrics from Pgpool2 resulted in an error (1 for error, 0 for success)",
@@ -28,18 +80,133 @@ var (
 		"Duration of the last scrape of metrics from Pgpool2",
 		nil, nil,
 	)
+	PoolPassFileRecreations = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "pcppass_recreations_total"),
+		"Number of times the PCP password file managed by the exporter was missing or modified and had to be recreated",
+		nil, nil,
+	)
+	PoolPassFileFailures = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "pcppass_validation_failures_total"),
+		"Number of times the PCP password file was found missing, modified or readable by others before a scrape",
+		nil, nil,
+	)
+	PoolEndpointActive = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "pcp_endpoint_active"),
+		"Whether the PCP endpoint served the last scrape of a target with several endpoints",
+		[]string{"endpoint"}, nil,
+	)
 	PoolNodeCount = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "node_count"),
+		prometheus.BuildFQName(namespace, "", "nodes"),
 		"Displays the total number of database nodes",
 		nil, nil,
 	)
 	PoolNodeInfo = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "node_info"),
 		"Displays the information of node",
-		[]string{"id", "node", "port", "width", "role", "replicationDelay", "replicationState", "replicationSyncState", "lastStatusChange"}, nil,
+		[]string{"id", "node", "port", "weight", "role", "replication_delay", "replication_state", "replication_sync_state", "last_status_change"}, nil,
+	)
+	PoolClusterModeInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "cluster_mode_info"),
+		"Clustering mode of Pgpool2 (streaming_replication, native_replication, logical_replication, slony, snapshot_isolation or raw)",
+		[]string{"mode"}, nil,
+	)
+	PoolVersionInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "version_info"),
+		"Pgpool version, by source: pcp_tools is the version of the local pcp tools, which is that of the pgpool scraped only where they are installed with it, pgpool is reported by the pgpool scraped, config is configured for the target",
+		[]string{"version", "source"}, nil,
+	)
+	PoolVersionChanges = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "version_changes_total"),
+		"Times the detected pgpool version differed from the one detected before, e.g. after an upgrade in place",
+		nil, nil,
+	)
+	ExporterCapability = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "capability"),
+		"Whether the detected pgpool version has a feature the exporter uses (1) or not (0), metrics taken from missing features are not exported; with source pcp_tools it is a capability of the local pcp tools",
+		[]string{"name", "source"}, nil,
+	)
+	PoolNodeInfoError = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "node_info_error"),
+		"Whether pcp_node_info failed for a configured node id in the last scrape (1 for error, 0 for success)",
+		[]string{"id"}, nil,
+	)
+	PoolDuplicateBackends = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "config", "duplicate_backends"),
+		"Number of backend nodes that report the same hostname and port as a node with a lower id",
+		nil, nil,
+	)
+	ConfigNumInitChildren = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "config", "num_init_children"),
+		"Number of child processes pgpool preforks, num_init_children reported by pcp_pool_status",
+		nil, nil,
+	)
+	ConfigMaxPool = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "config", "max_pool"),
+		"Number of backend connections a child process caches, max_pool reported by pcp_pool_status",
+		nil, nil,
+	)
+	ConfigChildLifeTime = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "config", "child_life_time_seconds"),
+		"Time after which an idle child process is replaced, child_life_time reported by pcp_pool_status (0 never)",
+		nil, nil,
+	)
+	ConfigConnectionLifeTime = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "config", "connection_life_time_seconds"),
+		"Time after which a cached backend connection is closed, connection_life_time reported by pcp_pool_status (0 never)",
+		nil, nil,
+	)
+	PoolBackendRoleChanges = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "backend_role_changes_total"),
+		"Number of times the role of the backend node changed, e.g. from standby to primary, since the exporter started",
+		[]string{"id", "node"}, nil,
+	)
+	PoolBackendStatements = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "backend_statements_total"),
+		"Number of statements pgpool sent to the backend node since it started by type, and of the panic, fatal and error messages the node returned (SQL mode, Pgpool-II 4.2+)",
+		[]string{"id", "node", "type"}, nil,
+	)
+	PoolBackendStatementRates = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "backend_statements_per_second"),
+		"Statements per second pgpool sent to the backend node between the last two background collections by type, for consumers that cannot compute rates (SQL mode with collect.interval)",
+		[]string{"id", "node", "type"}, nil,
+	)
+	PoolStatsResets = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "stats_resets_total"),
+		"Number of times the statement counters of pgpool went down since the exporter started, as pgpool restarted or they were reset (SQL mode)",
+		nil, nil,
+	)
+	PoolBackendLastStatusChange = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "backend_last_status_change_timestamp_seconds"),
+		"Time of the last status change of the backend node since unix epoch in seconds",
+		[]string{"id", "node"}, nil,
+	)
+	PoolBackendReplicationDelayBytes = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "backend_replication_delay_bytes"),
+		"Replication delay of the backend node behind the primary in bytes of WAL, as reported by pcp_node_info",
+		[]string{"id", "node"}, nil,
+	)
+	PoolBackendReplicationDelaySeconds = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "backend_replication_delay_seconds"),
+		"Replication delay of the backend node behind the primary in seconds, as reported by pcp_node_info with delay_threshold_by_time",
+		[]string{"id", "node"}, nil,
+	)
+	PoolBackendPGVersionInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "backend_pg_version_info"),
+		"PostgreSQL version of the backend node, queried on the backend with -backend.dsn",
+		[]string{"id", "node", "version"}, nil,
+	)
+	PoolNodeDNSLookupSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "node_dns_lookup_success"),
+		"Whether the hostname of the backend node resolved in the last scrape",
+		[]string{"id", "node"}, nil,
+	)
+	PoolNodeDNSLookupDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "node_dns_lookup_duration_seconds"),
+		"Duration of the DNS lookup of the backend node hostname",
+		[]string{"id", "node"}, nil,
 	)
 	PoolProcCount = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "proc_count"),
+		prometheus.BuildFQName(namespace, "", "child_processes"),
 		"Displays number of all Pgpool-II children processes",
 		nil, nil,
 	)
@@ -53,8 +220,43 @@ var (
 		"Displays number of all inactive connections to all Pgpool-II children processes",
 		[]string{"database"}, nil,
 	)
+	PoolFreeChildren = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "frontend_free_children"),
+		"Displays number of Pgpool-II children processes waiting for a client connection, new clients have to wait when it is 0 (Pgpool-II 4.2+)",
+		nil, nil,
+	)
+	PoolDatabaseConnectionLimit = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "database_connection_limit"),
+		"Expected connection ceiling of the database from the config file",
+		[]string{"database"}, nil,
+	)
+	PoolBackendMaintenance = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "backend_maintenance"),
+		"Whether the backend node is marked as in maintenance by the operator, only exported for marked nodes",
+		[]string{"id"}, nil,
+	)
+	PoolMaxClientIdleDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "frontend_max_client_idle_seconds"),
+		"Displays the longest idle duration of connected clients (Pgpool-II 4.2+)",
+		[]string{"database"}, nil,
+	)
+	PoolOldestClientConnection = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "frontend_oldest_client_connection_timestamp_seconds"),
+		"Connection time of the longest connected client since unix epoch in seconds",
+		[]string{"database"}, nil,
+	)
+	ExporterCacheHits = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "cache_hits_total"),
+		"Scrapes that got what a collector collected in an earlier run instead of running its PCP commands, by collector with a minimum interval",
+		[]string{"collector"}, nil,
+	)
+	ExporterClockSkew = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "clock_skew_seconds"),
+		"How far the latest client connection time reported by pgpool lies ahead of the exporter clock, a lower bound of how far the pgpool clock runs ahead or its time zone is off; 0 if not ahead",
+		nil, nil,
+	)
 	WatchdogTotalNodes = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "watchdog", "nodes_total"),
+		prometheus.BuildFQName(namespace, "watchdog", "nodes"),
 		"Watchdog total nodes",
 		nil, nil,
 	)
@@ -73,6 +275,56 @@ var (
 		"Watchdog virtual IP",
 		nil, nil,
 	)
+	WatchdogVIPReachable = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "vip_reachable"),
+		"Whether a TCP connection to pgpool through the delegate IP succeeded in the last scrape",
+		[]string{"address"}, nil,
+	)
+	WatchdogVIPConnectDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "vip_connect_duration_seconds"),
+		"Duration of the TCP connect to pgpool through the delegate IP",
+		[]string{"address"}, nil,
+	)
+	WatchdogMemberRemoteNodes = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "nodes_member_remote"),
+		"Watchdog remote nodes that are members counted for the quorum (Pgpool-II 4.3+)",
+		nil, nil,
+	)
+	WatchdogQuorumNodesRequired = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "quorum_nodes_required"),
+		"Watchdog nodes that have to be alive for the quorum, including the local node (Pgpool-II 4.3+)",
+		nil, nil,
+	)
+	WatchdogNodeMember = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "node_member"),
+		"Whether the watchdog node is a member counted for the quorum (Pgpool-II 4.3+)",
+		[]string{"name", "hostname"}, nil,
+	)
+	WatchdogRemoteNodeAlive = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "remote_node_alive"),
+		"Whether the remote watchdog node is alive, i.e. not dead, lost, shut down or isolated",
+		[]string{"name", "hostname"}, nil,
+	)
+	WatchdogNodeStatus = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "node_status"),
+		"Status code of the watchdog node as reported by pcp_watchdog_info, with its status name",
+		[]string{"name", "hostname", "status"}, nil,
+	)
+	WatchdogNodeLeader = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "node_leader"),
+		"Whether the watchdog node is the leader, which holds the delegate IP",
+		[]string{"name", "hostname"}, nil,
+	)
+	WatchdogNodePriority = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "node_priority"),
+		"Priority of the watchdog node in the leader election, wd_priority",
+		[]string{"name", "hostname"}, nil,
+	)
+	WatchdogSource = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "source_info"),
+		"PCP endpoint the watchdog metrics were collected from, another member of a target with several endpoints if pcp_watchdog_info failed on the active one",
+		[]string{"endpoint"}, nil,
+	)
 	WatchdogQuorumState = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "watchdog", "quorum_state"),
 		"Watchdog quorum state (1 is ok)",
@@ -80,68 +332,847 @@ var (
 	)
 )
 
+// poolStatusGauges are the parameters of pcp_pool_status exported by the
+// pool_status collector, the limits that the connection metrics saturate
+// against.
+var poolStatusGauges = map[string]*prometheus.Desc{
+	"num_init_children":    ConfigNumInitChildren,
+	"max_pool":             ConfigMaxPool,
+	"child_life_time":      ConfigChildLifeTime,
+	"connection_life_time": ConfigConnectionLifeTime,
+}
+
+// watchdogNodeLeader are the states of the leader watchdog node, called
+// master before pgpool 4.2
+var watchdogNodeLeader = map[string]bool{
+	"LEADER": true,
+	"MASTER": true,
+}
+
+// watchdogNodeDead are the states of watchdog nodes that are not alive, as
+// reported by pcp_watchdog_info
+var watchdogNodeDead = map[string]bool{
+	"DEAD":               true,
+	"LOST":               true,
+	"SHUTDOWN":           true,
+	"IN NETWORK TROUBLE": true,
+	"NETWORK ISOLATION":  true,
+}
+
+// metric names used before the naming cleanup, only exported in v0 compatibility mode
+var (
+	legacyPoolNodeCount = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "node_count"),
+		"Displays the total number of database nodes (deprecated, use pgpool2_nodes)",
+		nil, nil,
+	)
+	// only the label names changed, so this replaces PoolNodeInfo instead of
+	// being exported next to it
+	legacyPoolNodeInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "node_info"),
+		"Displays the information of node",
+		[]string{"id", "node", "port", "width", "role", "replicationDelay", "replicationState", "replicationSyncState", "lastStatusChange"}, nil,
+	)
+	legacyPoolProcCount = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "proc_count"),
+		"Displays number of all Pgpool-II children processes (deprecated, use pgpool2_child_processes)",
+		nil, nil,
+	)
+	legacyWatchdogTotalNodes = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "nodes_total"),
+		"Watchdog total nodes (deprecated, use pgpool2_watchdog_nodes)",
+		nil, nil,
+	)
+)
+
+type ExporterOptions struct {
+	// MetricsCompat is MetricsCompatV0 or MetricsCompatNone
+	MetricsCompat string
+	// NodeIDs are the backend node ids to collect, nil collects 0 up to the
+	// node count
+	NodeIDs []int
+	// ResolveNodes looks up the backend hostnames in DNS on every scrape
+	ResolveNodes bool
+	// CollectorWeights divide the scrape deadline between the collectors,
+	// collectors that are not listed have weight 1
+	CollectorWeights map[string]float64
+	// ClusterMode is the clustering mode of pgpool, one of
+	// pgpool2.ClusterModes, ClusterModeAuto or empty if unknown
+	ClusterMode string
+	// FailedCollectors is FailedCollectorsLast, FailedCollectorsFirst or
+	// FailedCollectorsKeep
+	FailedCollectors string
+	// NodeDetail is the default NodeDetailBasic, NodeDetailStandard or
+	// NodeDetailFull, a scrape can ask for another one with WithNodeDetail
+	NodeDetail string
+	// VIPAddress is the delegate IP of the watchdog as host[:port], probed
+	// with a TCP connect if not empty
+	VIPAddress string
+	// DatabaseConnectionLimits are exported as they are, by database
+	DatabaseConnectionLimits map[string]int
+	// CollectorIntervals are the minimum intervals between runs of the
+	// collectors, what they collected last is served in between
+	CollectorIntervals map[string]time.Duration
+	// CacheTTL is the minimum interval of the collectors that have none in
+	// CollectorIntervals, 0 runs them on every scrape
+	CacheTTL time.Duration
+	// MaintenanceNodes are the backend nodes marked as in maintenance, shared
+	// with the admin API
+	MaintenanceNodes *MaintenanceNodes
+	// NodeInfoOverrides are given to the PCP clients of the target
+	NodeInfoOverrides pgpool2.NodeInfoOverrides
+	// NodeRefreshInterval is the maximum age of the node infos served
+	// while pcp_pool_status reports no backend status change, 0 queries
+	// pcp_node_info every time
+	NodeRefreshInterval time.Duration
+	// Timezone is the time zone of pgpool that the times in the PCP outputs
+	// are in, nil for the local time zone
+	Timezone *time.Location
+	// CollectMode is CollectModePCP or CollectModeSQL, empty for PCP
+	CollectMode string
+	// VersionSource is where the version of the client comes from,
+	// VersionSourcePCPTools, VersionSourcePgpool or VersionSourceConfig
+	VersionSource string
+	// Version is the configured pgpool version, nil to detect it
+	Version *pgpool2.Version
+	// RoleChangePolls is the number of consecutive collections that have to
+	// report the new role of a node before the change is counted, 0 or 1
+	// counts it at once
+	RoleChangePolls int
+	// NodeInfoConcurrency is the number of pcp_node_info commands run at
+	// once, 0 or 1 runs them one after the other
+	NodeInfoConcurrency int
+	// BackendDSN is the lib/pq connection string to query the PostgreSQL
+	// version of the backend nodes with, empty to not query them
+	BackendDSN string
+	// HealthWeights replace the default weights of the inputs of
+	// pgpool2_cluster_health, by input
+	HealthWeights map[string]float64
+	// HealthMaxReplicationLag is the replication delay up to which a standby
+	// counts as in sync, 0 for one WAL segment
+	HealthMaxReplicationLag float64
+	// StatementRates exports the statement rates of the backend nodes
+	// between two collections, meant for background collection where they
+	// are collect.interval apart
+	StatementRates bool
+}
+
+// nodeSweep is the outcome of a collection of all nodes, served again while
+// the backend statuses stay the same.
+type nodeSweep struct {
+	time      time.Time
+	nodeCount int
+	statuses  map[int]string
+	infos     map[int]pgpool2.NodeInfo
+}
+
+type cachedCollection struct {
+	time    time.Time
+	metrics []prometheus.Metric
+}
+
 type Exporter struct {
-	pgpool *pgpool2.Client
+	pgpool  *pgpool2.Client
+	options ExporterOptions
+	logger  *logrus.Entry
+	// what the exporter keeps between scrapes, shared by the exporters of
+	// all endpoints of a target so that it carries over a failover
+	*exporterState
+	// endpoint is the PCP address of pgpool, and watchdogPeers the other
+	// endpoints of its target, asked for the watchdog info when pgpool fails
+	// to report it
+	endpoint      string
+	watchdogPeers []watchdogPeer
+}
+
+// exporterState is what an Exporter keeps between scrapes.
+type exporterState struct {
+	// collectors registered with collector.Register, by name
+	extraCollectors map[string]collector.Collector
+
+	mutex sync.Mutex
+	// duration of the last run of each collector, used to shed the slowest
+	// collectors first when a scrape deadline is short
+	lastDurations map[string]time.Duration
+	// collectors that failed in the last scrape
+	lastFailed map[string]bool
+	// last successful run of the collectors with a minimum interval, by
+	// name and node detail
+	cache map[string]cachedCollection
+	// scrapes served from the cache, by collector
+	cacheHits map[string]uint64
+	// clustering mode detected with ClusterModeAuto
+	clusterMode           string
+	clusterModeDetectedAt time.Time
+	// pgpool version detected with the pcp tools
+	version           *pgpool2.Version
+	versionDetectedAt time.Time
+	versionChanges    int
+	lastScrape        ScrapeStatus
+	// last role and number of role changes of the backend nodes, by id
+	roles       map[int]string
+	roleChanges map[int]int
+	// last statement counters of the backend nodes by id and type, and the
+	// number of times they went down
+	statements   map[int]map[string]uint64
+	statementsAt time.Time
+	statsResets  int
+	// new role of the backend nodes not yet reported RoleChangePolls times
+	pendingRoles map[int]pendingRole
+	// last collection of all nodes with NodeRefreshInterval
+	lastSweep *nodeSweep
+	// addresses of the backend nodes in the last node collection, by id
+	backends map[int]backendAddress
+}
+
+// watchdogPeer is another watchdog member of the same cluster.
+type watchdogPeer struct {
+	endpoint string
+	client   *pgpool2.Client
+}
+
+// ScrapeStatus is the outcome of one collection from Pgpool2.
+type ScrapeStatus struct {
+	Time     time.Time
+	Duration time.Duration
+	// Up is true if at least one PCP command succeeded
+	Up     bool
+	Errors []string
+}
+
+type collectorFunc func(ctx context.Context, ch chan<- prometheus.Metric) error
+
+type namedCollector struct {
+	name    string
+	collect collectorFunc
+	// backends is true for a collector that connects to the backend nodes
+	// instead of pgpool, whose success does not make pgpool2_up
+	backends bool
+	// sql is true for a collector of what only the SQL listener of pgpool
+	// reports, which only runs in CollectModeSQL
+	sql bool
 }
 
 func init() {
 	prometheus.MustRegister(version.NewCollector(exporterName))
 }
 
-func NewExporter(pgpool *pgpool2.Client) *Exporter {
-	return &Exporter{
-		pgpool: pgpool,
+func NewExporter(pgpool *pgpool2.Client, options ExporterOptions) (*Exporter, error) {
+	e := &Exporter{
+		pgpool:  pgpool,
+		options: options,
+		logger:  logrus.NewEntry(logrus.StandardLogger()),
+		exporterState: &exporterState{
+			extraCollectors: make(map[string]collector.Collector),
+			lastDurations:   make(map[string]time.Duration),
+			lastFailed:      make(map[string]bool),
+			cache:           make(map[string]cachedCollection),
+			cacheHits:       make(map[string]uint64),
+			roles:           make(map[int]string),
+			roleChanges:     make(map[int]int),
+			pendingRoles:    make(map[int]pendingRole),
+		},
+	}
+	builtin := make(map[string]bool)
+	for _, c := range e.builtinCollectors() {
+		builtin[c.name] = true
+	}
+	for _, name := range collector.Names() {
+		if builtin[name] {
+			return nil, fmt.Errorf("registered collector %s conflicts with a built-in collector", name)
+		}
+		c, err := collector.New(name)
+		if err != nil {
+			return nil, err
+		}
+		e.extraCollectors[name] = c
+	}
+	return e, nil
+}
+
+func (e *Exporter) builtinCollectors() []namedCollector {
+	return []namedCollector{
+		{name: "node", collect: e.collectNodeMetrics},
+		{name: "proc_count", collect: e.collectProcCountMetrics},
+		{name: "proc_info", collect: e.collectProcInfoMetrics},
+		{name: "watchdog", collect: e.collectWatchdogInfoMetrics},
+		{name: "pool_status", collect: e.collectPoolStatusMetrics},
+		{name: "backend_version", collect: e.collectBackendVersionMetrics, backends: true},
+		{name: "backend_stats", collect: e.collectBackendStatsMetrics, sql: true},
+	}
+}
+
+// collectorNames returns the names of the built-in and registered collectors.
+func collectorNames() []string {
+	var names []string
+	for _, c := range (&Exporter{}).builtinCollectors() {
+		names = append(names, c.name)
+	}
+	return append(names, collector.Names()...)
+}
+
+func (e *Exporter) collectorWeight(name string) float64 {
+	if weight, ok := e.options.CollectorWeights[name]; ok {
+		return weight
+	}
+	return 1
+}
+
+// collectors returns the built-in collectors followed by the registered ones.
+func (e *Exporter) collectors() []namedCollector {
+	var collectors []namedCollector
+	for _, c := range e.builtinCollectors() {
+		if c.backends && len(e.options.BackendDSN) == 0 {
+			continue
+		}
+		if c.sql && e.options.CollectMode != CollectModeSQL {
+			continue
+		}
+		collectors = append(collectors, c)
+	}
+	for _, name := range collector.Names() {
+		c, ok := e.extraCollectors[name]
+		if !ok {
+			continue
+		}
+		name := name
+		collectors = append(collectors, namedCollector{
+			name: name,
+			collect: func(ctx context.Context, ch chan<- prometheus.Metric) error {
+				if err := c.Update(ctx, e.pgpool, ch); err != nil {
+					return fmt.Errorf("%s collector error: %v", name, err)
+				}
+				return nil
+			},
+		})
 	}
+	return collectors
 }
 
-func (e *Exporter) collectNodeMetrics(ch chan<- prometheus.Metric) error {
-	nodeCount, err := e.pgpool.ExecNodeCount()
+type nodeDetailKey struct{}
+
+// WithNodeDetail returns a context that makes the collection bound to it
+// export nodes with the given detail instead of the configured one.
+func WithNodeDetail(ctx context.Context, detail string) context.Context {
+	return context.WithValue(ctx, nodeDetailKey{}, detail)
+}
+
+func (e *Exporter) nodeDetail(ctx context.Context) string {
+	if detail, ok := ctx.Value(nodeDetailKey{}).(string); ok {
+		return detail
+	}
+	if len(e.options.NodeDetail) == 0 {
+		return NodeDetailFull
+	}
+	return e.options.NodeDetail
+}
+
+func isNodeDetail(detail string) bool {
+	return detail == NodeDetailBasic || detail == NodeDetailStandard || detail == NodeDetailFull
+}
+
+func (e *Exporter) nodeInfoDesc() *prometheus.Desc {
+	if e.options.MetricsCompat == MetricsCompatV0 {
+		return legacyPoolNodeInfo
+	}
+	return PoolNodeInfo
+}
+
+// sendRenamedGauge sends a gauge of a renamed metric, and in v0 compatibility
+// mode the same sample under its legacy name.
+func (e *Exporter) sendRenamedGauge(ch chan<- prometheus.Metric, desc *prometheus.Desc, legacyDesc *prometheus.Desc, value float64, labelValues ...string) {
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labelValues...)
+	if e.options.MetricsCompat == MetricsCompatV0 {
+		ch <- prometheus.MustNewConstMetric(legacyDesc, prometheus.GaugeValue, value, labelValues...)
+	}
+}
+
+func (e *Exporter) collectNodeMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	nodeCount, err := e.pgpool.ExecNodeCountContext(ctx)
 	if err != nil {
 		return fmt.Errorf("ExecNodeCount() error: %v", err)
 	}
-	ch <- prometheus.MustNewConstMetric(
-		PoolNodeCount,
-		prometheus.GaugeValue,
-		float64(nodeCount),
-	)
-	for i := 0; i < nodeCount; i++ {
-		nodeInfo, err := e.pgpool.ExecNodeInfo(i)
+	e.sendRenamedGauge(ch, PoolNodeCount, legacyPoolNodeCount, float64(nodeCount))
+	if version := e.versionOf(ctx); version != nil {
+		source := e.versionSource()
+		ch <- prometheus.MustNewConstMetric(PoolVersionInfo, prometheus.GaugeValue, 1, version.String(), source)
+		e.mutex.Lock()
+		versionChanges := e.versionChanges
+		e.mutex.Unlock()
+		ch <- prometheus.MustNewConstMetric(PoolVersionChanges, prometheus.CounterValue, float64(versionChanges))
+		for _, capability := range pgpool2.Capabilities {
+			available := 0.0
+			if version.AtLeast(capability.Since) {
+				available = 1
+			}
+			ch <- prometheus.MustNewConstMetric(ExporterCapability, prometheus.GaugeValue, available, capability.Name, source)
+		}
+	}
+	detail := e.nodeDetail(ctx)
+	hasReplication := false
+	if detail == NodeDetailFull {
+		clusterMode := e.clusterModeOf(ctx)
+		if len(clusterMode) != 0 {
+			ch <- prometheus.MustNewConstMetric(PoolClusterModeInfo, prometheus.GaugeValue, 1, clusterMode)
+		}
+		// pgpool reports replication delay and state only for streaming
+		// replication, zeros in other modes would be misleading
+		hasReplication = len(clusterMode) == 0 || clusterMode == pgpool2.ClusterModeStreamingReplication
+	}
+	nodeIDs := e.options.NodeIDs
+	if nodeIDs == nil {
+		for i := 0; i < nodeCount; i++ {
+			nodeIDs = append(nodeIDs, i)
+		}
+	}
+	cached, statuses := e.cachedNodeInfos(ctx, nodeCount)
+	sweep := &nodeSweep{
+		time:      time.Now(),
+		nodeCount: nodeCount,
+		statuses:  statuses,
+		infos:     make(map[int]pgpool2.NodeInfo),
+	}
+	results := e.nodeInfos(ctx, nodeIDs, cached)
+	// node ids by backend address
+	addresses := make(map[string][]int)
+	backends := make(map[int]backendAddress)
+	var nodeErrors []string
+	for _, i := range nodeIDs {
+		nodeInfo, err := results[i].info, results[i].err
 		if err != nil {
-			return fmt.Errorf("ExecNodeInfo(%d) error: %v", i, err)
+			sweep = nil
+		} else if sweep != nil {
+			sweep.infos[i] = nodeInfo
+		}
+		if e.options.NodeIDs != nil {
+			// a configured id that is gone is reported, not a scrape error
+			nodeInfoError := 0.0
+			if err != nil {
+				if ctx.Err() != nil {
+					return fmt.Errorf("ExecNodeInfo(%d) error: %v", i, err)
+				}
+				e.logger.Warnf("ExecNodeInfo(%d) error: %v", i, err)
+				nodeInfoError = 1
+			}
+			ch <- prometheus.MustNewConstMetric(PoolNodeInfoError, prometheus.GaugeValue, nodeInfoError, strconv.Itoa(i))
+			if err != nil {
+				continue
+			}
+		} else if err != nil {
+			// the other nodes are still exported
+			nodeErrors = append(nodeErrors, fmt.Sprintf("ExecNodeInfo(%d) error: %v", i, err))
+			continue
+		}
+		if len(nodeInfo.Hostname) != 0 {
+			address := net.JoinHostPort(strings.ToLower(nodeInfo.Hostname), strconv.Itoa(nodeInfo.Port))
+			addresses[address] = append(addresses[address], i)
+			backends[i] = backendAddress{host: nodeInfo.Hostname, port: nodeInfo.Port}
+		}
+		weight, replicationDelay := "", ""
+		if detail == NodeDetailBasic {
+			nodeInfo.Role = ""
+			nodeInfo.LastStatusChange = ""
+		} else {
+			weight = strconv.FormatFloat(nodeInfo.Weight, 'f', 6, 64)
+		}
+		if hasReplication {
+			replicationDelay = strconv.FormatFloat(nodeInfo.ReplicationDelay, 'f', 6, 64)
+		} else {
+			nodeInfo.ReplicationState = ""
+			nodeInfo.ReplicationSyncState = ""
 		}
 		ch <- prometheus.MustNewConstMetric(
-			PoolNodeInfo,
+			e.nodeInfoDesc(),
 			prometheus.GaugeValue,
 			float64(nodeInfo.StatusCode),
 			strconv.Itoa(i),
 			nodeInfo.Hostname,
 			strconv.Itoa(nodeInfo.Port),
-			strconv.FormatFloat(nodeInfo.Weight, 'f', 6, 64),
+			weight,
 			nodeInfo.Role,
-			strconv.FormatFloat(nodeInfo.ReplicationDelay, 'f', 6, 64),
+			replicationDelay,
 			nodeInfo.ReplicationState,
 			nodeInfo.ReplicationSyncState,
 			nodeInfo.LastStatusChange,
 		)
+		// basic detail leaves out the role
+		if detail != NodeDetailBasic {
+			ch <- prometheus.MustNewConstMetric(
+				PoolBackendRoleChanges,
+				prometheus.CounterValue,
+				float64(e.countRoleChange(i, nodeInfo.Role)),
+				strconv.Itoa(i),
+				nodeInfo.Hostname,
+			)
+			if changed, ok := pgpool2.ParseTimestamp(nodeInfo.LastStatusChange, e.timezone()); ok {
+				ch <- prometheus.MustNewConstMetric(
+					PoolBackendLastStatusChange,
+					prometheus.GaugeValue,
+					float64(changed.Unix()),
+					strconv.Itoa(i),
+					nodeInfo.Hostname,
+				)
+			}
+		}
+		if hasReplication {
+			switch nodeInfo.ReplicationDelayUnit {
+			case pgpool2.ReplicationDelayBytes:
+				ch <- prometheus.MustNewConstMetric(PoolBackendReplicationDelayBytes, prometheus.GaugeValue, nodeInfo.ReplicationDelay, strconv.Itoa(i), nodeInfo.Hostname)
+			case pgpool2.ReplicationDelaySeconds:
+				ch <- prometheus.MustNewConstMetric(PoolBackendReplicationDelaySeconds, prometheus.GaugeValue, nodeInfo.ReplicationDelay, strconv.Itoa(i), nodeInfo.Hostname)
+			}
+		}
+		if e.options.ResolveNodes && detail == NodeDetailFull {
+			e.collectNodeDNSMetrics(ctx, ch, i, nodeInfo.Hostname)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(PoolDuplicateBackends, prometheus.GaugeValue, float64(e.duplicateBackends(addresses)))
+	e.mutex.Lock()
+	e.backends = backends
+	e.mutex.Unlock()
+	if len(nodeErrors) != 0 {
+		return errors.New(strings.Join(nodeErrors, "; "))
+	}
+	// a sweep without statuses would be served until it is too old
+	if cached == nil && sweep != nil && statuses != nil {
+		e.mutex.Lock()
+		e.lastSweep = sweep
+		e.mutex.Unlock()
 	}
 	return nil
 }
 
-func (e *Exporter) collectProcCountMetrics(ch chan<- prometheus.Metric) error {
-	procArr, err := e.pgpool.ExecProcCount()
+// nodeInfoResult is the outcome of pcp_node_info for one node.
+type nodeInfoResult struct {
+	info pgpool2.NodeInfo
+	err  error
+}
+
+// nodeInfos returns the node infos of the node ids, from cached or queried
+// with up to NodeInfoConcurrency pcp_node_info commands at once.
+func (e *Exporter) nodeInfos(ctx context.Context, nodeIDs []int, cached map[int]pgpool2.NodeInfo) map[int]nodeInfoResult {
+	concurrency := e.options.NodeInfoConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make(map[int]nodeInfoResult, len(nodeIDs))
+	var (
+		mutex sync.Mutex
+		wg    sync.WaitGroup
+	)
+	slots := make(chan struct{}, concurrency)
+	for _, id := range nodeIDs {
+		if nodeInfo, ok := cached[id]; ok {
+			results[id] = nodeInfoResult{info: nodeInfo}
+			continue
+		}
+		id := id
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nodeInfo, err := e.pgpool.ExecNodeInfoContext(ctx, id)
+			<-slots
+			mutex.Lock()
+			results[id] = nodeInfoResult{info: nodeInfo, err: err}
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// duplicateBackends logs the backend addresses that several node ids report
+// and returns the number of node ids that repeat the address of another one.
+// Pgpool runs with such a configuration, but balances and fails over between
+// what is one server.
+func (e *Exporter) duplicateBackends(addresses map[string][]int) int {
+	duplicates := 0
+	for address, ids := range addresses {
+		if len(ids) < 2 {
+			continue
+		}
+		duplicates += len(ids) - 1
+		e.logger.Warnf("Backend nodes %v have the same address %s, check the backend_hostname and backend_port settings of pgpool", ids, address)
+	}
+	return duplicates
+}
+
+// cachedNodeInfos returns the node infos of the last sweep if it is recent
+// enough and pcp_pool_status reports the same backend statuses, nil if all
+// nodes have to be queried. Any status change queries all nodes again, as a
+// failover also changes the role of nodes whose status stays the same. The
+// statuses are returned for the next sweep.
+func (e *Exporter) cachedNodeInfos(ctx context.Context, nodeCount int) (map[int]pgpool2.NodeInfo, map[int]string) {
+	if e.options.NodeRefreshInterval <= 0 {
+		return nil, nil
+	}
+	params, err := e.pgpool.ExecPoolStatusContext(ctx)
+	if err != nil {
+		e.logger.Warnf("ExecPoolStatus() error, querying all nodes: %v", err)
+		return nil, nil
+	}
+	statuses := pgpool2.BackendStatuses(params)
+	if len(statuses) == 0 {
+		e.logger.Debug("pcp_pool_status reports no backend status, querying all nodes")
+		return nil, nil
+	}
+	e.mutex.Lock()
+	last := e.lastSweep
+	e.mutex.Unlock()
+	if last == nil || last.nodeCount != nodeCount || time.Since(last.time) >= e.options.NodeRefreshInterval {
+		return nil, statuses
+	}
+	if len(last.statuses) != len(statuses) {
+		return nil, statuses
+	}
+	for id, status := range statuses {
+		if last.statuses[id] != status {
+			e.logger.Infof("Backend status of node %d changed from %s to %s, querying all nodes", id, last.statuses[id], status)
+			return nil, statuses
+		}
+	}
+	return last.infos, statuses
+}
+
+// pendingRole is a new role of a node and the number of consecutive
+// collections that reported it.
+type pendingRole struct {
+	role  string
+	polls int
+}
+
+// countRoleChange records the role of the node and returns the number of
+// times it changed. An empty role, e.g. of a node pgpool cannot reach, is not
+// a change. With RoleChangePolls, a new role only counts once it was reported
+// that many times in a row, so that a role flapping during a PCP hiccup does
+// not count.
+func (e *Exporter) countRoleChange(id int, role string) int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if len(role) == 0 {
+		return e.roleChanges[id]
+	}
+	if last := e.roles[id]; len(last) != 0 && last != role {
+		pending := e.pendingRoles[id]
+		if pending.role != role {
+			pending = pendingRole{role: role}
+		}
+		pending.polls++
+		if pending.polls < e.options.RoleChangePolls {
+			e.pendingRoles[id] = pending
+			e.logger.Debugf("Backend node %d reports role %s instead of %s %d time(s)", id, role, last, pending.polls)
+			return e.roleChanges[id]
+		}
+		e.roleChanges[id]++
+		e.logger.Infof("Backend node %d changed role from %s to %s", id, last, role)
+	}
+	delete(e.pendingRoles, id)
+	e.roles[id] = role
+	return e.roleChanges[id]
+}
+
+// clusterModeOf returns the configured clustering mode, or with
+// ClusterModeAuto the one detected with pcp_pool_status. A failed detection is
+// not a scrape error, the mode is unknown then.
+func (e *Exporter) clusterModeOf(ctx context.Context) string {
+	if e.options.ClusterMode != ClusterModeAuto {
+		return e.options.ClusterMode
+	}
+	e.mutex.Lock()
+	if time.Since(e.clusterModeDetectedAt) < clusterModeTTL {
+		defer e.mutex.Unlock()
+		return e.clusterMode
+	}
+	e.mutex.Unlock()
+	params, err := e.pgpool.ExecPoolStatusContext(ctx)
+	if err != nil {
+		e.logger.Warnf("Cannot detect cluster mode: ExecPoolStatus() error: %v", err)
+		return ""
+	}
+	clusterMode := pgpool2.DetectClusterMode(params)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if clusterMode != e.clusterMode {
+		e.logger.Infof("Detected cluster mode %q", clusterMode)
+	}
+	e.clusterMode = clusterMode
+	e.clusterModeDetectedAt = time.Now()
+	return clusterMode
+}
+
+// versionSource returns where the version of versionOf comes from.
+func (e *Exporter) versionSource() string {
+	if len(e.options.VersionSource) == 0 {
+		return VersionSourcePCPTools
+	}
+	return e.options.VersionSource
+}
+
+// versionOf returns the configured pgpool version or the one detected with the
+// pcp tools, nil if it is unknown. A failed detection is not a scrape error.
+func (e *Exporter) versionOf(ctx context.Context) *pgpool2.Version {
+	if e.options.Version != nil {
+		return e.options.Version
+	}
+	e.mutex.Lock()
+	if time.Since(e.versionDetectedAt) < versionTTL {
+		defer e.mutex.Unlock()
+		return e.version
+	}
+	e.mutex.Unlock()
+	version, err := e.pgpool.VersionContext(ctx)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if err != nil {
+		e.logger.Warnf("Cannot detect the pgpool version: %v", err)
+		// pcp tools without --version are not asked again on every
+		// scrape, a scrape that ran out of time is
+		if ctx.Err() == nil {
+			e.versionDetectedAt = time.Now()
+			e.version = nil
+		}
+		return e.version
+	}
+	e.versionDetectedAt = time.Now()
+	if e.version == nil {
+		e.logger.Infof("Detected pgpool version %s", version)
+	} else if *e.version != version {
+		e.logger.Infof("Pgpool version changed from %s to %s", e.version, version)
+		e.versionChanges++
+	}
+	e.version = &version
+	return e.version
+}
+
+// collectNodeDNSMetrics resolves the hostname of a backend node, as pgpool
+// hides a stale DNS entry until it has to connect anew, e.g. on failover.
+// Lookup failures are reported, not scrape errors.
+func (e *Exporter) collectNodeDNSMetrics(ctx context.Context, ch chan<- prometheus.Metric, id int, hostname string) {
+	start := time.Now()
+	_, err := net.DefaultResolver.LookupHost(ctx, hostname)
+	duration := time.Since(start)
+	success := 1.0
+	if err != nil {
+		e.logger.Warnf("Cannot resolve node %d hostname %s: %v", id, hostname, err)
+		success = 0
+	}
+	ch <- prometheus.MustNewConstMetric(PoolNodeDNSLookupSuccess, prometheus.GaugeValue, success, strconv.Itoa(id), hostname)
+	ch <- prometheus.MustNewConstMetric(PoolNodeDNSLookupDuration, prometheus.GaugeValue, duration.Seconds(), strconv.Itoa(id), hostname)
+}
+
+func (e *Exporter) collectProcCountMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	procArr, err := e.pgpool.ExecProcCountContext(ctx)
 	if err != nil {
 		return fmt.Errorf("ExecProcCount() error: %v", err)
 	}
-	ch <- prometheus.MustNewConstMetric(
-		PoolProcCount,
-		prometheus.GaugeValue,
-		float64(len(procArr)),
-	)
+	e.sendRenamedGauge(ch, PoolProcCount, legacyPoolProcCount, float64(len(procArr)))
 	return nil
 }
 
-func (e *Exporter) collectProcInfoMetrics(ch chan<- prometheus.Metric) error {
-	procInfoArr, err := e.pgpool.ExecProcInfo()
+// collectBackendVersionMetrics queries the PostgreSQL version of the backend
+// nodes of the last node collection, to show mixed versions during an
+// upgrade. A backend that cannot be queried is logged and left out, as a
+// failed standby does not fail the scrape either.
+func (e *Exporter) collectBackendVersionMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	e.mutex.Lock()
+	backends := e.backends
+	e.mutex.Unlock()
+	for id, backend := range backends {
+		version, err := backendVersion(ctx, e.options.BackendDSN, backend)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("backend %d version error: %v", id, ctx.Err())
+			}
+			e.logger.Warnf("Cannot query the version of backend node %d: %v", id, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(PoolBackendPGVersionInfo, prometheus.GaugeValue, 1, strconv.Itoa(id), backend.host, version)
+	}
+	return nil
+}
+
+// collectPoolStatusMetrics exports the configured limits of pgpool. A
+// parameter that pgpool does not report or that is not a number is left out.
+func (e *Exporter) collectPoolStatusMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	params, err := e.pgpool.ExecPoolStatusContext(ctx)
+	if err != nil {
+		return fmt.Errorf("ExecPoolStatus() error: %v", err)
+	}
+	for _, param := range params {
+		desc, ok := poolStatusGauges[param.Name]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(param.Value), 64)
+		if err != nil {
+			e.logger.Debugf("Cannot parse %s of pcp_pool_status: %v", param.Name, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+	return nil
+}
+
+// collectBackendStatsMetrics exports the statement counters of the backend
+// nodes. Counters lower than in the last collection mean that pgpool
+// restarted or reset them, which is counted for consumers that cannot tell a
+// reset from the values. With StatementRates the counters are also exported
+// as rates since the last collection, except after a reset.
+func (e *Exporter) collectBackendStatsMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	stats, err := e.pgpool.ExecBackendStatsContext(ctx)
+	if err != nil {
+		return fmt.Errorf("ExecBackendStats() error: %v", err)
+	}
+	now := time.Now()
+	e.mutex.Lock()
+	reset := false
+	statements := make(map[int]map[string]uint64, len(stats))
+	rates := make(map[int]map[string]float64)
+	elapsed := now.Sub(e.statementsAt).Seconds()
+	for _, node := range stats {
+		for statement, count := range node.Statements {
+			last, ok := e.statements[node.NodeID][statement]
+			if !ok {
+				continue
+			}
+			if count < last {
+				reset = true
+			} else if e.options.StatementRates && elapsed > 0 {
+				if rates[node.NodeID] == nil {
+					rates[node.NodeID] = make(map[string]float64)
+				}
+				rates[node.NodeID][statement] = float64(count-last) / elapsed
+			}
+		}
+		statements[node.NodeID] = node.Statements
+	}
+	e.statements = statements
+	e.statementsAt = now
+	if reset {
+		// rates across the reset would mix two pgpool lifetimes
+		rates = nil
+		e.statsResets++
+		e.logger.Infof("Statement counters of pgpool went down, pgpool restarted or they were reset")
+	}
+	statsResets := e.statsResets
+	e.mutex.Unlock()
+	for _, node := range stats {
+		for statement, count := range node.Statements {
+			ch <- prometheus.MustNewConstMetric(PoolBackendStatements, prometheus.CounterValue, float64(count),
+				strconv.Itoa(node.NodeID), node.Hostname, statement)
+			if rate, ok := rates[node.NodeID][statement]; ok {
+				ch <- prometheus.MustNewConstMetric(PoolBackendStatementRates, prometheus.GaugeValue, rate,
+					strconv.Itoa(node.NodeID), node.Hostname, statement)
+			}
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(PoolStatsResets, prometheus.CounterValue, float64(statsResets))
+	return nil
+}
+
+func (e *Exporter) collectProcInfoMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	procInfoArr, err := e.pgpool.ExecProcInfoContext(ctx)
 	if err != nil {
 		return fmt.Errorf("ExecProcInfo() error: %v", err)
 	}
@@ -162,19 +1193,75 @@ func (e *Exporter) collectProcInfoMetrics(ch chan<- prometheus.Metric) error {
 			database,
 		)
 	}
+	for database, idle := range procSummary.MaxIdleDuration {
+		ch <- prometheus.MustNewConstMetric(
+			PoolMaxClientIdleDuration,
+			prometheus.GaugeValue,
+			float64(idle),
+			database,
+		)
+	}
+	oldest := make(map[string]time.Time)
+	var latest time.Time
+	for _, procInfo := range procInfoArr {
+		if !procInfo.Connected {
+			continue
+		}
+		connected, ok := pgpool2.ParseTimestamp(procInfo.ClientConnectionTime, e.timezone())
+		if !ok {
+			continue
+		}
+		if t, ok := oldest[procInfo.Database]; !ok || connected.Before(t) {
+			oldest[procInfo.Database] = connected
+		}
+		if connected.After(latest) {
+			latest = connected
+		}
+	}
+	// a client cannot connect in the future, the times are in whole seconds
+	// and never ahead of a clock in sync
+	if !latest.IsZero() {
+		skew := time.Until(latest)
+		if skew < 0 {
+			skew = 0
+		}
+		ch <- prometheus.MustNewConstMetric(ExporterClockSkew, prometheus.GaugeValue, skew.Seconds())
+	}
+	for database, connected := range oldest {
+		ch <- prometheus.MustNewConstMetric(
+			PoolOldestClientConnection,
+			prometheus.GaugeValue,
+			float64(connected.Unix()),
+			database,
+		)
+	}
+	if procSummary.FreeChildren >= 0 {
+		ch <- prometheus.MustNewConstMetric(
+			PoolFreeChildren,
+			prometheus.GaugeValue,
+			float64(procSummary.FreeChildren),
+		)
+	}
 	return nil
 }
 
-func (e *Exporter) collectWatchdogInfoMetrics(ch chan<- prometheus.Metric) error {
-	watchdogInfo, err := e.pgpool.ExecWatchdogInfo()
+// timezone returns the time zone of the times in the PCP outputs.
+func (e *Exporter) timezone() *time.Location {
+	if e.options.Timezone == nil {
+		return time.Local
+	}
+	return e.options.Timezone
+}
+
+func (e *Exporter) collectWatchdogInfoMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	watchdogInfo, source, err := e.watchdogInfo(ctx)
 	if err != nil {
 		return fmt.Errorf("ExecWatchdogInfo() error: %v", err)
 	}
-	ch <- prometheus.MustNewConstMetric(
-		WatchdogTotalNodes,
-		prometheus.GaugeValue,
-		float64(watchdogInfo.TotalNodes),
-	)
+	if len(e.watchdogPeers) != 0 {
+		ch <- prometheus.MustNewConstMetric(WatchdogSource, prometheus.GaugeValue, 1, source)
+	}
+	e.sendRenamedGauge(ch, WatchdogTotalNodes, legacyWatchdogTotalNodes, float64(watchdogInfo.TotalNodes))
 	ch <- prometheus.MustNewConstMetric(
 		WatchdogRemoteNodes,
 		prometheus.GaugeValue,
@@ -190,6 +1277,46 @@ func (e *Exporter) collectWatchdogInfoMetrics(ch chan<- prometheus.Metric) error
 		prometheus.GaugeValue,
 		float64(watchdogInfo.QuorumStateCode),
 	)
+	for _, node := range watchdogInfo.Nodes {
+		ch <- prometheus.MustNewConstMetric(WatchdogNodeStatus, prometheus.GaugeValue, float64(node.StatusCode), node.Name, node.Hostname, node.Status)
+		leader := 0.0
+		if watchdogNodeLeader[node.Status] {
+			leader = 1
+		}
+		ch <- prometheus.MustNewConstMetric(WatchdogNodeLeader, prometheus.GaugeValue, leader, node.Name, node.Hostname)
+		ch <- prometheus.MustNewConstMetric(WatchdogNodePriority, prometheus.GaugeValue, float64(node.Priority), node.Name, node.Hostname)
+	}
+	// the first node is the local one
+	for i, node := range watchdogInfo.Nodes {
+		if i == 0 {
+			continue
+		}
+		alive := 1.0
+		if watchdogNodeDead[node.Status] {
+			alive = 0
+		}
+		ch <- prometheus.MustNewConstMetric(WatchdogRemoteNodeAlive, prometheus.GaugeValue, alive, node.Name, node.Hostname)
+	}
+	// older versions report neither the quorum size nor the membership
+	if watchdogInfo.QuorumNodesRequired > 0 {
+		ch <- prometheus.MustNewConstMetric(
+			WatchdogMemberRemoteNodes,
+			prometheus.GaugeValue,
+			float64(watchdogInfo.MemberRemoteNodes),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			WatchdogQuorumNodesRequired,
+			prometheus.GaugeValue,
+			float64(watchdogInfo.QuorumNodesRequired),
+		)
+		for _, node := range watchdogInfo.Nodes {
+			member := 0.0
+			if node.Membership == "MEMBER" {
+				member = 1
+			}
+			ch <- prometheus.MustNewConstMetric(WatchdogNodeMember, prometheus.GaugeValue, member, node.Name, node.Hostname)
+		}
+	}
 	if watchdogInfo.VIP {
 		ch <- prometheus.MustNewConstMetric(
 			WatchdogVIP,
@@ -203,42 +1330,261 @@ func (e *Exporter) collectWatchdogInfoMetrics(ch chan<- prometheus.Metric) error
 			0.0,
 		)
 	}
+	if len(e.options.VIPAddress) != 0 {
+		e.collectVIPMetrics(ctx, ch)
+	}
 	return nil
 }
 
+// watchdogInfo returns the watchdog info of pgpool, or of the first peer that
+// reports it if pgpool fails to, e.g. while the watchdog of a dying leader
+// still answers pcp_node_count. It also returns the endpoint that reported it.
+func (e *Exporter) watchdogInfo(ctx context.Context) (pgpool2.WatchdogInfo, string, error) {
+	watchdogInfo, err := e.pgpool.ExecWatchdogInfoContext(ctx)
+	if err == nil || ctx.Err() != nil {
+		return watchdogInfo, e.endpoint, err
+	}
+	for _, peer := range e.watchdogPeers {
+		peerInfo, peerErr := peer.client.ExecWatchdogInfoContext(ctx)
+		if peerErr == nil {
+			e.logger.Warnf("Collected the watchdog info from %s, ExecWatchdogInfo() error: %v", peer.endpoint, err)
+			return peerInfo, peer.endpoint, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return watchdogInfo, e.endpoint, err
+}
+
+// collectVIPMetrics connects to pgpool through the delegate IP, which shows
+// whether the VIP moved to a live pgpool after a failover.
+func (e *Exporter) collectVIPMetrics(ctx context.Context, ch chan<- prometheus.Metric) {
+	address := e.options.VIPAddress
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultVIPPort)
+	}
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	duration := time.Since(start)
+	reachable := 1.0
+	if err != nil {
+		e.logger.Warnf("Cannot connect to the delegate IP %s: %v", address, err)
+		reachable = 0
+	} else {
+		conn.Close()
+	}
+	ch <- prometheus.MustNewConstMetric(WatchdogVIPReachable, prometheus.GaugeValue, reachable, address)
+	ch <- prometheus.MustNewConstMetric(WatchdogVIPConnectDuration, prometheus.GaugeValue, duration.Seconds(), address)
+}
+
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	var scrapeError bool
+	e.CollectContext(context.Background(), ch)
+}
+
+// CollectContext collects all metrics, cancelling PCP commands when ctx is
+// done. With a deadline, collectors run fastest first and the ones that took
+// longer last time than the time left are skipped.
+func (e *Exporter) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	var (
+		scrapeErrors []string
+		up           bool
+	)
 
-	defer func(begun time.Time) {
+	begun := time.Now()
+	defer func() {
+		duration := time.Since(begun)
 		ch <- prometheus.MustNewConstMetric(
 			PoolLastScrapeDuration,
 			prometheus.GaugeValue,
-			time.Since(begun).Seconds(),
+			duration.Seconds(),
 		)
-	}(time.Now())
+		e.mutex.Lock()
+		e.lastScrape = ScrapeStatus{
+			Time:     begun,
+			Duration: duration,
+			Up:       up,
+			Errors:   scrapeErrors,
+		}
+		e.mutex.Unlock()
+	}()
 
-	if err := e.collectNodeMetrics(ch); err != nil {
-		scrapeError = true
-		logrus.Error(err)
+	// e.g. a tmp cleaner may have removed the password file
+	if err := e.pgpool.CheckPassFile(); err != nil {
+		scrapeErrors = append(scrapeErrors, err.Error())
+		e.logger.Error(err)
+	}
+	// summed over the clients of all endpoints, which keeps the counters
+	// from jumping on a failover
+	recreations, failures := e.pgpool.PassFileRecreations(), e.pgpool.PassFileFailures()
+	for _, peer := range e.watchdogPeers {
+		recreations += peer.client.PassFileRecreations()
+		failures += peer.client.PassFileFailures()
+	}
+	ch <- prometheus.MustNewConstMetric(
+		PoolPassFileRecreations,
+		prometheus.CounterValue,
+		float64(recreations),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		PoolPassFileFailures,
+		prometheus.CounterValue,
+		float64(failures),
+	)
+	for database, limit := range e.options.DatabaseConnectionLimits {
+		ch <- prometheus.MustNewConstMetric(
+			PoolDatabaseConnectionLimit,
+			prometheus.GaugeValue,
+			float64(limit),
+			database,
+		)
+	}
+	for _, id := range e.options.MaintenanceNodes.IDs() {
+		ch <- prometheus.MustNewConstMetric(
+			PoolBackendMaintenance,
+			prometheus.GaugeValue,
+			1,
+			strconv.Itoa(id),
+		)
 	}
 
-	if err := e.collectProcCountMetrics(ch); err != nil {
-		scrapeError = true
-		logrus.Error(err)
+	collectors := e.collectors()
+	deadline, hasDeadline := ctx.Deadline()
+	e.mutex.Lock()
+	if hasDeadline {
+		sort.SliceStable(collectors, func(i, j int) bool {
+			return e.lastDurations[collectors[i].name] < e.lastDurations[collectors[j].name]
+		})
+	}
+	// e.g. a watchdog command that keeps failing should not use up the
+	// time of the node collector
+	if e.options.FailedCollectors == FailedCollectorsLast || e.options.FailedCollectors == FailedCollectorsFirst {
+		failedFirst := e.options.FailedCollectors == FailedCollectorsFirst
+		sort.SliceStable(collectors, func(i, j int) bool {
+			failedI, failedJ := e.lastFailed[collectors[i].name], e.lastFailed[collectors[j].name]
+			return failedI != failedJ && failedI == failedFirst
+		})
 	}
+	e.mutex.Unlock()
 
-	if err := e.collectProcInfoMetrics(ch); err != nil {
-		scrapeError = true
-		logrus.Error(err)
+	// with a deadline every collector gets a share of the time left by its
+	// weight, so a hanging one cannot use up the time of the ones after it
+	var remainingWeight float64
+	for _, c := range collectors {
+		remainingWeight += e.collectorWeight(c.name)
 	}
 
-	if err := e.collectWatchdogInfoMetrics(ch); err != nil {
-		scrapeError = true
-		logrus.Error(err)
+	// the health of the cluster is taken from what the collectors send,
+	// which may come from the cache
+	health := newClusterHealth(e.options.HealthMaxReplicationLag)
+	collected := make(chan prometheus.Metric)
+	collectedDone := make(chan struct{})
+	go func() {
+		for m := range collected {
+			health.observe(m)
+			ch <- m
+		}
+		close(collectedDone)
+	}()
+
+	cachedOnly := len(collectors) != 0
+	versionChecked := false
+	for _, c := range collectors {
+		weight := e.collectorWeight(c.name)
+		remainingWeight -= weight
+		if metrics, ok := e.cachedMetrics(ctx, c.name); ok {
+			for _, m := range metrics {
+				collected <- m
+			}
+			continue
+		}
+		cachedOnly = false
+		if ctx.Err() != nil {
+			err := fmt.Errorf("skipping %s collector: %v", c.name, ctx.Err())
+			scrapeErrors = append(scrapeErrors, err.Error())
+			e.logger.Warn(err)
+			continue
+		}
+		collectorCtx, cancel := ctx, context.CancelFunc(func() {})
+		var budget time.Duration
+		if hasDeadline {
+			e.mutex.Lock()
+			expected := e.lastDurations[c.name]
+			left := time.Until(deadline)
+			if left < expected {
+				// expect it to be faster next time, so that one slow run does
+				// not get a collector skipped forever
+				e.lastDurations[c.name] = expected / 2
+			}
+			e.mutex.Unlock()
+			if left < expected {
+				err := fmt.Errorf("skipping %s collector: took %s last time, %s left before the scrape timeout", c.name, expected, left)
+				scrapeErrors = append(scrapeErrors, err.Error())
+				e.logger.Warn(err)
+				continue
+			}
+			// a collector may exceed its share by what it took last time,
+			// as the cheaper ones run first
+			budget = time.Duration(float64(left) * weight / (weight + remainingWeight))
+			if budget < expected {
+				budget = expected
+			}
+			collectorCtx, cancel = context.WithTimeout(ctx, budget)
+		}
+		collectorBegun := time.Now()
+		err := e.runCollectorRetried(collectorCtx, c, collected, &versionChecked)
+		// stop the timer of the collector now rather than when the scrape
+		// ends, after telling whether it ran out of its share
+		exceeded := collectorCtx.Err() != nil
+		cancel()
+		e.mutex.Lock()
+		e.lastDurations[c.name] = time.Since(collectorBegun)
+		e.lastFailed[c.name] = err != nil
+		e.mutex.Unlock()
+		if err != nil {
+			if exceeded && ctx.Err() == nil {
+				err = fmt.Errorf("%s collector exceeded its share of %s of the scrape timeout: %v", c.name, budget, err)
+			}
+			scrapeErrors = append(scrapeErrors, err.Error())
+			e.logger.Error(err)
+			continue
+		}
+		if !c.backends {
+			up = true
+		}
+	}
+	close(collected)
+	<-collectedDone
+	for _, c := range collectors {
+		if e.collectorInterval(c.name) <= 0 {
+			continue
+		}
+		e.mutex.Lock()
+		hits := e.cacheHits[c.name]
+		e.mutex.Unlock()
+		ch <- prometheus.MustNewConstMetric(ExporterCacheHits, prometheus.CounterValue, float64(hits), c.name)
+	}
+
+	// no PCP command ran if all collectors were served from the cache
+	if cachedOnly {
+		e.mutex.Lock()
+		up = e.lastScrape.Up
+		e.mutex.Unlock()
 	}
+	upFloat := 0.0
+	if up {
+		upFloat = 1.0
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		PoolUp,
+		prometheus.GaugeValue,
+		upFloat,
+	)
 
 	scrapeErrorFloat := 0.0
-	if scrapeError {
+	if len(scrapeErrors) != 0 {
 		scrapeErrorFloat = 1.0
 	}
 
@@ -247,19 +1593,199 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 		prometheus.GaugeValue,
 		scrapeErrorFloat,
 	)
+	ch <- prometheus.MustNewConstMetric(
+		ClusterHealth,
+		prometheus.GaugeValue,
+		health.value(e.options.HealthWeights, up),
+	)
+}
+
+// collectionKey identifies the cached collection of a collector, which
+// depends on the node detail of the scrape.
+func (e *Exporter) collectionKey(ctx context.Context, name string) string {
+	return name + "/" + e.nodeDetail(ctx)
+}
+
+// cachedMetrics returns what a collector with a minimum interval collected in
+// its last run, if that was less than the interval ago.
+func (e *Exporter) cachedMetrics(ctx context.Context, name string) ([]prometheus.Metric, bool) {
+	interval := e.collectorInterval(name)
+	if interval <= 0 {
+		return nil, false
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	cached, ok := e.cache[e.collectionKey(ctx, name)]
+	if !ok || time.Since(cached.time) >= interval {
+		return nil, false
+	}
+	e.cacheHits[name]++
+	return cached.metrics, true
+}
+
+// collectorInterval returns the minimum interval between runs of a
+// collector, 0 if it runs on every scrape.
+func (e *Exporter) collectorInterval(name string) time.Duration {
+	if interval, ok := e.options.CollectorIntervals[name]; ok {
+		return interval
+	}
+	return e.options.CacheTTL
+}
+
+// runCollector runs a collector, and keeps what it collected if it has a
+// minimum interval and succeeded.
+func (e *Exporter) runCollector(ctx context.Context, c namedCollector, ch chan<- prometheus.Metric) error {
+	if e.collectorInterval(c.name) <= 0 {
+		return c.collect(ctx, ch)
+	}
+	buffer := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	var metrics []prometheus.Metric
+	go func() {
+		for m := range buffer {
+			metrics = append(metrics, m)
+			ch <- m
+		}
+		close(done)
+	}()
+	err := c.collect(ctx, buffer)
+	close(buffer)
+	<-done
+
+	key := e.collectionKey(ctx, c.name)
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if err != nil {
+		// do not serve the last success after a failure
+		delete(e.cache, key)
+		return err
+	}
+	e.cache[key] = cachedCollection{time: time.Now(), metrics: metrics}
+	return nil
+}
+
+// runCollectorRetried runs a collector, and runs it once more if it failed
+// after it succeeded last time and the pgpool version changed since it was
+// detected, as the commands of a scrape during a rolling upgrade can reach two
+// pgpool versions. What the failed run collected is dropped. The version is
+// detected again at most once per scrape, which checked tracks. Only a version
+// reported by pgpool can change under a collector, the version of the local
+// pcp tools does not follow an upgrade of pgpool, so the collector just runs
+// otherwise.
+func (e *Exporter) runCollectorRetried(ctx context.Context, c namedCollector, ch chan<- prometheus.Metric, checked *bool) error {
+	if e.versionSource() != VersionSourcePgpool {
+		return e.runCollector(ctx, c, ch)
+	}
+	e.mutex.Lock()
+	failedBefore := e.lastFailed[c.name]
+	e.mutex.Unlock()
+	if failedBefore || *checked {
+		return e.runCollector(ctx, c, ch)
+	}
+	buffer := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	var metrics []prometheus.Metric
+	go func() {
+		for m := range buffer {
+			metrics = append(metrics, m)
+		}
+		close(done)
+	}()
+	err := e.runCollector(ctx, c, buffer)
+	close(buffer)
+	<-done
+	if err != nil && ctx.Err() == nil {
+		*checked = true
+		if e.versionChanged(ctx) {
+			e.logger.Warnf("Running the %s collector again after the pgpool version changed: %v", c.name, err)
+			return e.runCollector(ctx, c, ch)
+		}
+	}
+	for _, m := range metrics {
+		ch <- m
+	}
+	return err
+}
+
+// versionChanged detects the pgpool version again and reports whether it
+// differs from the one detected before, which it replaces.
+func (e *Exporter) versionChanged(ctx context.Context) bool {
+	e.mutex.Lock()
+	before := e.version
+	e.versionDetectedAt = time.Time{}
+	e.mutex.Unlock()
+	after := e.versionOf(ctx)
+	return before != nil && after != nil && *before != *after
+}
+
+// LastScrape returns the outcome of the last collection, the zero value if
+// there was none yet.
+func (e *Exporter) LastScrape() ScrapeStatus {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.lastScrape
 }
 
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- PoolUp
 	ch <- PoolLastScrapeError
 	ch <- PoolLastScrapeDuration
+	ch <- PoolPassFileRecreations
+	ch <- PoolPassFileFailures
 	ch <- PoolNodeCount
 	ch <- PoolProcCount
-	ch <- PoolNodeInfo
+	ch <- e.nodeInfoDesc()
+	ch <- PoolClusterModeInfo
+	ch <- PoolVersionInfo
+	ch <- PoolVersionChanges
+	ch <- ExporterCapability
+	ch <- PoolNodeInfoError
+	ch <- PoolDuplicateBackends
+	ch <- ConfigNumInitChildren
+	ch <- ClusterHealth
+	ch <- ExporterCacheHits
+	ch <- ConfigMaxPool
+	ch <- ConfigChildLifeTime
+	ch <- ConfigConnectionLifeTime
+	ch <- PoolBackendRoleChanges
+	ch <- PoolBackendStatements
+	ch <- PoolBackendStatementRates
+	ch <- PoolStatsResets
+	ch <- PoolBackendLastStatusChange
+	ch <- PoolBackendReplicationDelayBytes
+	ch <- PoolBackendReplicationDelaySeconds
+	ch <- PoolBackendPGVersionInfo
+	ch <- PoolNodeDNSLookupSuccess
+	ch <- PoolNodeDNSLookupDuration
 	ch <- PoolNumberActiveConnections
 	ch <- PoolNumberInactiveConnections
+	ch <- PoolFreeChildren
+	ch <- PoolDatabaseConnectionLimit
+	ch <- PoolBackendMaintenance
+	ch <- PoolMaxClientIdleDuration
+	ch <- PoolOldestClientConnection
+	ch <- ExporterClockSkew
 	ch <- WatchdogTotalNodes
 	ch <- WatchdogRemoteNodes
 	ch <- WatchdogAliveRemoteNodes
+	ch <- WatchdogVIPReachable
+	ch <- WatchdogVIPConnectDuration
+	ch <- WatchdogRemoteNodeAlive
+	ch <- WatchdogMemberRemoteNodes
+	ch <- WatchdogQuorumNodesRequired
+	ch <- WatchdogNodeMember
 	ch <- WatchdogQuorumState
+	ch <- WatchdogSource
+	ch <- WatchdogNodeStatus
+	ch <- WatchdogNodeLeader
+	ch <- WatchdogNodePriority
 	ch <- WatchdogVIP
+	if e.options.MetricsCompat == MetricsCompatV0 {
+		ch <- legacyPoolNodeCount
+		ch <- legacyPoolProcCount
+		ch <- legacyWatchdogTotalNodes
+	}
+	for _, c := range e.extraCollectors {
+		c.Describe(ch)
+	}
 }
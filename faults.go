@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+)
+
+// faultFlagPrefix marks the fault injection flags, which are meant for staging
+// only and left out of the usage.
+const faultFlagPrefix = "debug.fault-"
+
+var (
+	faultLatency   = flag.Duration("debug.fault-latency", 0, "Delay every PCP command by this duration")
+	faultFailures  = flag.Float64("debug.fault-failure-ratio", 0, "Ratio of PCP commands that fail without running")
+	faultMalformed = flag.Float64("debug.fault-malformed-ratio", 0, "Ratio of PCP commands whose output is cut in half")
+)
+
+func faultsEnabled() bool {
+	return *faultLatency > 0 || *faultFailures > 0 || *faultMalformed > 0
+}
+
+func validateFaults() error {
+	if *faultLatency < 0 {
+		return fmt.Errorf("fault latency must not be negative")
+	}
+	if *faultFailures < 0 || *faultFailures > 1 {
+		return fmt.Errorf("fault failure ratio must be between 0 and 1")
+	}
+	if *faultMalformed < 0 || *faultMalformed > 1 {
+		return fmt.Errorf("fault malformed ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// withFaults wraps executor to inject the configured faults.
+func withFaults(executor pgpool2.Executor) pgpool2.Executor {
+	if !faultsEnabled() {
+		return executor
+	}
+	return faultExecutor{
+		executor:       executor,
+		latency:        *faultLatency,
+		failureRatio:   *faultFailures,
+		malformedRatio: *faultMalformed,
+	}
+}
+
+// faultExecutor delays, fails or truncates PCP commands, to check alert rules
+// and partial failures end to end.
+type faultExecutor struct {
+	executor       pgpool2.Executor
+	latency        time.Duration
+	failureRatio   float64
+	malformedRatio float64
+}
+
+func (e faultExecutor) Exec(ctx context.Context, parse func(io.Reader) error, cmd string, args []string, env []string) error {
+	if e.latency > 0 {
+		timer := time.NewTimer(e.latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if rand.Float64() < e.failureRatio {
+		return fmt.Errorf("injected failure of %s", cmd)
+	}
+	if rand.Float64() < e.malformedRatio {
+		return e.executor.Exec(ctx, func(r io.Reader) error {
+			out, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			return parse(bytes.NewReader(out[:len(out)/2]))
+		}, cmd, args, env)
+	}
+	return e.executor.Exec(ctx, parse, cmd, args, env)
+}
+
+// printVisibleDefaults prints the usage of all flags but the fault injection
+// ones.
+func printVisibleDefaults() {
+	visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	visible.SetOutput(os.Stderr)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, faultFlagPrefix) {
+			visible.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	visible.PrintDefaults()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// listenFDsEnv tells a process started by handOver how many listeners it
+// inherits, starting at file descriptor 3: the web listener and, if enabled,
+// the admin listener.
+const listenFDsEnv = "PGPOOL2_EXPORTER_LISTEN_FDS"
+
+// shutdownTimeout bounds how long the previous process serves running
+// requests after a handover.
+const shutdownTimeout = 30 * time.Second
+
+// listen returns the inherited listener at index i, or a new listener on
+// address if there is none.
+func listen(inherited []net.Listener, i int, address string) (net.Listener, error) {
+	if i < len(inherited) {
+		return inherited[i], nil
+	}
+	return net.Listen("tcp", address)
+}
+
+// isHandOverSignal returns whether s is one of handOverSignals.
+func isHandOverSignal(s os.Signal) bool {
+	for _, handOverSignal := range handOverSignals {
+		if s == handOverSignal {
+			return true
+		}
+	}
+	return false
+}
+
+// shutdownServers stops accepting connections and waits for running requests.
+func shutdownServers(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		server.Shutdown(ctx)
+	}
+}
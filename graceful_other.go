@@ -0,0 +1,21 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// handOverSignals is empty, listeners cannot be inherited by file descriptor
+// here.
+var handOverSignals []os.Signal
+
+func inheritedListeners() ([]net.Listener, error) {
+	return nil, nil
+}
+
+func handOver(listeners []net.Listener) (*os.Process, error) {
+	return nil, errors.New("listener handover not supported on this platform")
+}
@@ -0,0 +1,67 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// handOverSignals make the exporter hand over its listeners to a new process.
+var handOverSignals = []os.Signal{syscall.SIGUSR2}
+
+// inheritedListeners returns the listeners handed over by the previous
+// process, if any.
+func inheritedListeners() ([]net.Listener, error) {
+	value := os.Getenv(listenFDsEnv)
+	if len(value) == 0 {
+		return nil, nil
+	}
+	os.Unsetenv(listenFDsEnv)
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", listenFDsEnv, value)
+	}
+	listeners := make([]net.Listener, 0, count)
+	for i := 0; i < count; i++ {
+		f := os.NewFile(uintptr(3+i), fmt.Sprintf("listener%d", i))
+		listener, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot inherit listener %d: %v", i, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// handOver starts a new exporter process from the current executable with the
+// same arguments, which takes over the listeners so upgrades leave no gap in
+// scrapes.
+func handOver(listeners []net.Listener) (*os.Process, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	for _, listener := range listeners {
+		tcpListener, ok := listener.(*net.TCPListener)
+		if !ok {
+			return nil, fmt.Errorf("cannot hand over listener on %s", listener.Addr())
+		}
+		f, err := tcpListener.File()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+	env := append(os.Environ(), fmt.Sprintf("%s=%d", listenFDsEnv, len(listeners)))
+	return os.StartProcess(path, os.Args, &os.ProcAttr{
+		Env:   env,
+		Files: files,
+	})
+}
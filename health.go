@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Inputs of pgpool2_cluster_health, the keys of health_weights.
+const (
+	HealthPrimary        = "primary"
+	HealthStandbys       = "standbys"
+	HealthQuorum         = "quorum"
+	HealthSaturation     = "saturation"
+	HealthReplicationLag = "replication_lag"
+)
+
+// defaultHealthWeights weigh the inputs of pgpool2_cluster_health, a cluster
+// without primary is down whatever the rest looks like.
+var defaultHealthWeights = map[string]float64{
+	HealthPrimary:        4,
+	HealthStandbys:       2,
+	HealthQuorum:         2,
+	HealthSaturation:     1,
+	HealthReplicationLag: 1,
+}
+
+// defaultHealthMaxReplicationLag is the replication delay up to which a
+// standby counts as in sync, one WAL segment.
+const defaultHealthMaxReplicationLag = 16 * 1024 * 1024
+
+var ClusterHealth = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "cluster", "health"),
+	"Health of the cluster from 0 to 1, the weighted mean of the primary, standbys, watchdog quorum, free children and replication lag scores",
+	nil, nil,
+)
+
+// clusterHealth gathers the inputs of pgpool2_cluster_health from the metrics
+// of a scrape, so that collectors served from the cache count as well.
+type clusterHealth struct {
+	maxReplicationLag float64
+
+	primaryUp     bool
+	nodesWithRole int
+	standbys      int
+	// standbys that are up, by node id
+	standbysUp map[string]bool
+	// replication delays in bytes, by node id
+	delays       map[string]float64
+	quorum       float64
+	hasQuorum    bool
+	children     float64
+	freeChildren float64
+	hasChildren  bool
+	hasFree      bool
+}
+
+func newClusterHealth(maxReplicationLag float64) *clusterHealth {
+	if maxReplicationLag <= 0 {
+		maxReplicationLag = defaultHealthMaxReplicationLag
+	}
+	return &clusterHealth{
+		maxReplicationLag: maxReplicationLag,
+		standbysUp:        make(map[string]bool),
+		delays:            make(map[string]float64),
+	}
+}
+
+// observe takes the inputs out of a collected metric, others are ignored.
+func (h *clusterHealth) observe(m prometheus.Metric) {
+	desc := m.Desc()
+	if desc != PoolNodeInfo && desc != legacyPoolNodeInfo && desc != PoolProcCount &&
+		desc != legacyPoolProcCount && desc != PoolFreeChildren && desc != WatchdogQuorumState &&
+		desc != PoolBackendReplicationDelayBytes {
+		return
+	}
+	var metric dto.Metric
+	if err := m.Write(&metric); err != nil || metric.Gauge == nil {
+		return
+	}
+	value := metric.Gauge.GetValue()
+	labels := make(map[string]string, len(metric.Label))
+	for _, label := range metric.Label {
+		labels[label.GetName()] = label.GetValue()
+	}
+	switch desc {
+	case PoolNodeInfo, legacyPoolNodeInfo:
+		h.observeNode(labels, value)
+	case PoolBackendReplicationDelayBytes:
+		// the delay in seconds of delay_threshold_by_time is left out
+		h.delays[labels["id"]] = value
+	case PoolProcCount, legacyPoolProcCount:
+		h.children, h.hasChildren = value, true
+	case PoolFreeChildren:
+		h.freeChildren, h.hasFree = value, true
+	case WatchdogQuorumState:
+		switch int(value) {
+		case pgpool2.QuorumStateExist:
+			h.quorum, h.hasQuorum = 1, true
+		case pgpool2.QuorumStateOnEdge:
+			// one more lost node loses the quorum
+			h.quorum, h.hasQuorum = 0.5, true
+		case pgpool2.QuorumStateAbsent, pgpool2.QuorumStateNoMasterNode:
+			h.quorum, h.hasQuorum = 0, true
+		}
+	}
+}
+
+// observeNode counts a backend node by its role, basic node detail leaves
+// the role out. A node is up while pgpool reports it up or waiting.
+func (h *clusterHealth) observeNode(labels map[string]string, status float64) {
+	role := labels["role"]
+	if len(role) == 0 {
+		return
+	}
+	h.nodesWithRole++
+	up := status == 1 || status == 2
+	if role == "primary" || role == "main" || role == "master" {
+		h.primaryUp = h.primaryUp || up
+		return
+	}
+	h.standbys++
+	if up {
+		h.standbysUp[labels["id"]] = true
+	}
+}
+
+// scores returns the score from 0 to 1 of every input the scrape collected.
+func (h *clusterHealth) scores() map[string]float64 {
+	scores := make(map[string]float64)
+	if h.nodesWithRole != 0 {
+		scores[HealthPrimary] = 0
+		if h.primaryUp {
+			scores[HealthPrimary] = 1
+		}
+	}
+	if h.standbys != 0 {
+		scores[HealthStandbys] = float64(len(h.standbysUp)) / float64(h.standbys)
+	}
+	lagged, inSync := 0, 0
+	for id := range h.standbysUp {
+		delay, ok := h.delays[id]
+		if !ok {
+			continue
+		}
+		lagged++
+		if delay <= h.maxReplicationLag {
+			inSync++
+		}
+	}
+	if lagged != 0 {
+		scores[HealthReplicationLag] = float64(inSync) / float64(lagged)
+	}
+	if h.hasQuorum {
+		scores[HealthQuorum] = h.quorum
+	}
+	if h.hasChildren && h.hasFree && h.children > 0 {
+		scores[HealthSaturation] = h.freeChildren / h.children
+		if scores[HealthSaturation] > 1 {
+			scores[HealthSaturation] = 1
+		}
+	}
+	return scores
+}
+
+// value returns the weighted mean of the scores of the collected inputs, the
+// weights of the others are left out. It is 0 if pgpool was not reachable.
+func (h *clusterHealth) value(weights map[string]float64, up bool) float64 {
+	if !up {
+		return 0
+	}
+	var sum, total float64
+	for input, score := range h.scores() {
+		weight, ok := weights[input]
+		if !ok {
+			weight = defaultHealthWeights[input]
+		}
+		sum += weight * score
+		total += weight
+	}
+	if total == 0 {
+		return 1
+	}
+	return sum / total
+}
+
+// validateHealthWeights checks the health_weights of the configuration file.
+func validateHealthWeights(weights map[string]float64) error {
+	for input, weight := range weights {
+		if _, ok := defaultHealthWeights[input]; !ok {
+			return fmt.Errorf("health_weights has unknown input %s", input)
+		}
+		if weight < 0 {
+			return fmt.Errorf("health input %s has invalid weight %v", input, weight)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const defaultInitPath = "pgpool2_exporter.yml"
+
+// initTemplate is the starter config file written by the init command. Only
+// the target is filled in, the other sections are examples to uncomment.
+// Collectors cannot be turned off and the exporter serves no TLS, so the
+// template lists the collectors to slow down and points to the flags of the
+// listen address instead.
+var initTemplate = template.Must(template.New("config").Funcs(template.FuncMap{
+	"quote": func(s string) string {
+		// a JSON string is a valid YAML double quoted scalar
+		quoted, _ := json.Marshal(s)
+		return string(quoted)
+	},
+}).Parse(`# Starter configuration written by pgpool2_exporter init, run the exporter
+# with -config.file={{ .Path }}. See the README for every setting.
+targets:
+  - name: {{ quote .Name }}
+    host: {{ quote .Host }}
+    port: {{ .Port }}
+    username: {{ quote .Username }}
+{{- if .PassFile }}
+    passfile: {{ quote .PassFile }}
+{{- else if .Password }}
+    password: {{ quote .Password }}
+{{- end }}
+
+# Collectors, all of them run on every scrape. Collect some less often, or
+# give them a larger share of the scrape timeout:
+# collector_intervals:
+{{- range .Collectors }}
+#   {{ . }}: 1m
+{{- end }}
+# collector_weights:
+#   watchdog: 2
+
+# Only collect these backend node ids:
+# node_ids: "0-2"
+
+# Backend nodes in planned maintenance, left out of the failover counters:
+# maintenance_nodes: []
+
+# The web interface is plain HTTP, which is set with flags rather than here:
+# terminate TLS in a reverse proxy and pass -web.trusted-proxies, restrict
+# the clients with -web.allow-cidr, and keep the admin interface of
+# -web.admin-listen-address on localhost.
+`))
+
+type initAnswers struct {
+	Path     string
+	Name     string
+	Host     string
+	Port     int
+	Username string
+	PassFile string
+	Password string
+	// Collectors are the names of the collectors
+	Collectors []string
+}
+
+// runInit writes a starter config file to path. Interactively every answer
+// defaults to the value of the matching -pcp.* flag, otherwise the flags are
+// taken as they are.
+func runInit(path string, in io.Reader, out io.Writer, interactive bool) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s exists already", path)
+	}
+	answers := initAnswers{
+		Path:       path,
+		Name:       "pgpool",
+		Host:       *pcpHostname,
+		Port:       *pcpPort,
+		Username:   *pcpUsername,
+		PassFile:   *pcpPassFile,
+		Password:   *pcpPassword,
+		Collectors: collectorNames(),
+	}
+	if interactive {
+		reader := bufio.NewReader(in)
+		answers.Name = prompt(reader, out, "Target name", answers.Name)
+		answers.Host = prompt(reader, out, "PCP host", answers.Host)
+		for {
+			port, err := strconv.Atoi(prompt(reader, out, "PCP port", strconv.Itoa(answers.Port)))
+			if err == nil && port > 0 && port < 65536 {
+				answers.Port = port
+				break
+			}
+			fmt.Fprintln(out, "The port must be a number between 1 and 65535.")
+		}
+		answers.Username = prompt(reader, out, "PCP username", answers.Username)
+		answers.PassFile = prompt(reader, out, "PCP password file, or docker-secret://<name> (empty to store a password)", answers.PassFile)
+		if len(answers.PassFile) == 0 {
+			// not offered as default, it would be printed
+			if password := prompt(reader, out, "PCP password (shown while typing and stored in the file)", ""); len(password) != 0 {
+				answers.Password = password
+			}
+		}
+	}
+	if len(answers.PassFile) != 0 {
+		answers.Password = ""
+		// the config file has no docker-secret:// references, the secret
+		// only has to exist where the exporter runs
+		passFile, ok, err := dockerSecretPath(answers.PassFile)
+		if err != nil {
+			return err
+		}
+		if ok {
+			answers.PassFile = passFile
+		}
+	}
+
+	// the file may hold a password
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := initTemplate.Execute(f, answers); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if _, err := LoadConfig(path, ""); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Wrote %s, start the exporter with -config.file=%s\n", path, path)
+	return nil
+}
+
+// prompt asks the question and returns the answer, or value if the answer is
+// empty or the input ended.
+func prompt(reader *bufio.Reader, out io.Writer, question, value string) string {
+	if len(value) != 0 {
+		fmt.Fprintf(out, "%s [%s]: ", question, value)
+	} else {
+		fmt.Fprintf(out, "%s: ", question)
+	}
+	answer, _ := reader.ReadString('\n')
+	if answer = strings.TrimSpace(answer); len(answer) != 0 {
+		return answer
+	}
+	return value
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or a file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
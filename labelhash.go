@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var defaultHashedLabels = []string{"database", "username"}
+
+// LabelHashing replaces the values of tenant labels by a salted hash, for
+// sites where database and user names must not leave the host. The same name
+// always gets the same hash, so trends per tenant are kept.
+type LabelHashing struct {
+	Salt string `yaml:"salt"`
+	// Labels defaults to database and username
+	Labels []string `yaml:"labels"`
+}
+
+// hashingGatherer applies the label hashing to everything the wrapped
+// gatherer returns.
+type hashingGatherer struct {
+	gatherer prometheus.Gatherer
+	salt     []byte
+	labels   map[string]bool
+}
+
+func newHashingGatherer(gatherer prometheus.Gatherer, hashing *LabelHashing) prometheus.Gatherer {
+	if hashing == nil {
+		return gatherer
+	}
+	names := hashing.Labels
+	if len(names) == 0 {
+		names = defaultHashedLabels
+	}
+	g := &hashingGatherer{
+		gatherer: gatherer,
+		salt:     []byte(hashing.Salt),
+		labels:   make(map[string]bool),
+	}
+	for _, name := range names {
+		g.labels[name] = true
+	}
+	return g
+}
+
+func (g *hashingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	metricFamilies, err := g.gatherer.Gather()
+	for _, mf := range metricFamilies {
+		for _, m := range mf.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if g.labels[label.GetName()] {
+					value := g.hash(label.GetValue())
+					label.Value = &value
+				}
+			}
+		}
+		sortMetrics(mf)
+	}
+	return metricFamilies, err
+}
+
+// hash returns the first 64 bits of the HMAC-SHA256 of value, in hex.
+func (g *hashingGatherer) hash(value string) string {
+	mac := hmac.New(sha256.New, g.salt)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
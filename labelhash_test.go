@@ -0,0 +1,75 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+const hashingInput = `# TYPE pgpool2_frontend_used gauge
+pgpool2_frontend_used{database="db",pool_pid="101",username="alice"} 1
+`
+
+var hexHash = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+func TestHashingGatherer(t *testing.T) {
+	tests := []struct {
+		name      string
+		hashing   *LabelHashing
+		hashed    []string
+		unchanged []string
+	}{
+		{
+			name:      "disabled",
+			unchanged: []string{"database", "pool_pid", "username"},
+		},
+		{
+			name:      "default labels",
+			hashing:   &LabelHashing{Salt: "s"},
+			hashed:    []string{"database", "username"},
+			unchanged: []string{"pool_pid"},
+		},
+		{
+			name:      "configured labels",
+			hashing:   &LabelHashing{Salt: "s", Labels: []string{"username"}},
+			hashed:    []string{"username"},
+			unchanged: []string{"database", "pool_pid"},
+		},
+	}
+	want := map[string]string{"database": "db", "pool_pid": "101", "username": "alice"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metricFamilies, err := newHashingGatherer(textGatherer(t, hashingInput), tt.hashing).Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			labels := make(map[string]string)
+			for _, label := range metricFamilies[0].GetMetric()[0].GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			for _, name := range tt.hashed {
+				if !hexHash.MatchString(labels[name]) {
+					t.Errorf("label %s is %q, want a hash", name, labels[name])
+				}
+			}
+			for _, name := range tt.unchanged {
+				if labels[name] != want[name] {
+					t.Errorf("label %s is %q, want %q", name, labels[name], want[name])
+				}
+			}
+		})
+	}
+}
+
+func TestLabelHash(t *testing.T) {
+	salted := newHashingGatherer(nil, &LabelHashing{Salt: "s"}).(*hashingGatherer)
+	other := newHashingGatherer(nil, &LabelHashing{Salt: "t"}).(*hashingGatherer)
+	if salted.hash("alice") != salted.hash("alice") {
+		t.Error("the same value got different hashes")
+	}
+	if salted.hash("alice") == salted.hash("bob") {
+		t.Error("different values got the same hash")
+	}
+	if salted.hash("alice") == other.hash("alice") {
+		t.Error("different salts gave the same hash")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// protocolVersion3 is the version of the PostgreSQL frontend/backend protocol
+// sent in the startup packet.
+const protocolVersion3 = 3 << 16
+
+// probe results
+const (
+	listenerSuccess  = "success"
+	listenerRefused  = "refused"
+	listenerRejected = "rejected"
+	listenerTimeout  = "timeout"
+	listenerError    = "error"
+)
+
+// ListenerProber connects to the frontend listener of pgpool at an interval,
+// as PCP may stay healthy while all children are busy and new clients queue
+// in the listen backlog. With a user it also sends a startup packet and waits
+// for the first response, which a child only sends once it accepted the
+// connection.
+type ListenerProber struct {
+	address  string
+	user     string
+	interval time.Duration
+	timeout  time.Duration
+
+	connectDuration  prometheus.Gauge
+	responseDuration prometheus.Gauge
+	probes           *prometheus.CounterVec
+}
+
+func NewListenerProber(address, user string, interval, timeout time.Duration) *ListenerProber {
+	probes := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "listener",
+			Name:      "probes_total",
+			Help:      "Number of probes of the pgpool frontend listener by result (success, refused, rejected, timeout or error)",
+		},
+		[]string{"result"},
+	)
+	for _, result := range []string{listenerSuccess, listenerRefused, listenerRejected, listenerTimeout, listenerError} {
+		probes.WithLabelValues(result)
+	}
+	return &ListenerProber{
+		address:  address,
+		user:     user,
+		interval: interval,
+		timeout:  timeout,
+		connectDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "listener",
+			Name:      "connect_duration_seconds",
+			Help:      "Duration of the TCP connect of the last successful probe of the pgpool frontend listener",
+		}),
+		responseDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "listener",
+			Name:      "response_duration_seconds",
+			Help:      "Time from the startup packet to the first response of pgpool in the last successful probe",
+		}),
+		probes: probes,
+	}
+}
+
+func (p *ListenerProber) Describe(ch chan<- *prometheus.Desc) {
+	p.connectDuration.Describe(ch)
+	if len(p.user) != 0 {
+		p.responseDuration.Describe(ch)
+	}
+	p.probes.Describe(ch)
+}
+
+func (p *ListenerProber) Collect(ch chan<- prometheus.Metric) {
+	p.connectDuration.Collect(ch)
+	if len(p.user) != 0 {
+		p.responseDuration.Collect(ch)
+	}
+	p.probes.Collect(ch)
+}
+
+// Run probes the listener forever.
+func (p *ListenerProber) Run() {
+	for {
+		result := p.probe()
+		p.probes.WithLabelValues(result).Inc()
+		time.Sleep(p.interval)
+	}
+}
+
+func (p *ListenerProber) probe() string {
+	deadline := time.Now().Add(p.timeout)
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", p.address, p.timeout)
+	if err != nil {
+		logrus.Warnf("Cannot connect to the pgpool listener %s: %v", p.address, err)
+		return listenerResult(err)
+	}
+	defer conn.Close()
+	connected := time.Now()
+	if len(p.user) == 0 {
+		p.connectDuration.Set(connected.Sub(start).Seconds())
+		return listenerSuccess
+	}
+
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write(startupPacket(p.user)); err != nil {
+		logrus.Warnf("Cannot send the startup packet to the pgpool listener %s: %v", p.address, err)
+		return listenerResult(err)
+	}
+	// 'R' asks for authentication, 'E' is an error like "sorry, too many
+	// clients already"
+	messageType, err := bufio.NewReader(conn).ReadByte()
+	if err != nil {
+		logrus.Warnf("No response from the pgpool listener %s: %v", p.address, err)
+		return listenerResult(err)
+	}
+	if messageType == 'E' {
+		logrus.Warnf("The pgpool listener %s rejected the connection", p.address)
+		return listenerRejected
+	}
+	p.connectDuration.Set(connected.Sub(start).Seconds())
+	p.responseDuration.Set(time.Since(connected).Seconds())
+	return listenerSuccess
+}
+
+func listenerResult(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return listenerTimeout
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return listenerRefused
+	}
+	return listenerError
+}
+
+// startupPacket returns the startup message of the PostgreSQL protocol for
+// user and the database of the same name.
+func startupPacket(user string) []byte {
+	var params []byte
+	for _, param := range []string{"user", user, "database", user, "application_name", exporterName} {
+		params = append(append(params, param...), 0)
+	}
+	params = append(params, 0)
+	packet := make([]byte, 8, 8+len(params))
+	binary.BigEndian.PutUint32(packet[0:4], uint32(8+len(params)))
+	binary.BigEndian.PutUint32(packet[4:8], protocolVersion3)
+	return append(packet, params...)
+}
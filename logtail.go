@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// LogRule counts every pgpool log line matching Regexp as an event called Name.
+type LogRule struct {
+	Name   string
+	Regexp *regexp.Regexp
+}
+
+var defaultLogRules = []LogRule{
+	{Name: "failover_done", Regexp: regexp.MustCompile(`failover done`)},
+	{Name: "failback_done", Regexp: regexp.MustCompile(`failback done`)},
+	{Name: "degenerate_backend", Regexp: regexp.MustCompile(`degenerate backend request`)},
+	// the watchdog lifecheck reports a remote pgpool dead once its
+	// heartbeats stop for wd_heartbeat_deadtime, or once it cannot query it
+	// wd_life_point times in query mode
+	{Name: "watchdog_heartbeat_lost", Regexp: regexp.MustCompile(`No heartbeat signal from node`)},
+	{Name: "watchdog_lifecheck_failed", Regexp: regexp.MustCompile(`lifecheck failed`)},
+}
+
+// logRuleFlag collects repeated -log.rule name=regexp flags.
+type logRuleFlag []LogRule
+
+func (f *logRuleFlag) String() string {
+	rules := make([]string, 0, len(*f))
+	for _, rule := range *f {
+		rules = append(rules, rule.Name+"="+rule.Regexp.String())
+	}
+	return strings.Join(rules, ",")
+}
+
+func (f *logRuleFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return fmt.Errorf("log rule must be in the form name=regexp, got %q", value)
+	}
+	re, err := regexp.Compile(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid regexp for log rule %s: %v", parts[0], err)
+	}
+	*f = append(*f, LogRule{Name: parts[0], Regexp: re})
+	return nil
+}
+
+// mergeLogRules returns the default rules with custom ones appended; a custom
+// rule replaces the default rule of the same name.
+func mergeLogRules(custom []LogRule) []LogRule {
+	rules := make([]LogRule, 0, len(defaultLogRules)+len(custom))
+	for _, rule := range defaultLogRules {
+		overridden := false
+		for _, c := range custom {
+			if c.Name == rule.Name {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			rules = append(rules, rule)
+		}
+	}
+	return append(rules, custom...)
+}
+
+// LogTailer follows the pgpool log file and counts lines matching its rules,
+// covering events such as failovers that PCP does not expose. Lines logged
+// while planned reports true, i.e. while a node is in maintenance, are counted
+// separately so that planned detaches do not fire failover alerts.
+type LogTailer struct {
+	path          string
+	rules         []LogRule
+	interval      time.Duration
+	planned       func() bool
+	events        *prometheus.CounterVec
+	plannedEvents *prometheus.CounterVec
+}
+
+func NewLogTailer(path string, rules []LogRule, planned func() bool) *LogTailer {
+	events := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "log_events_total",
+			Help:      "Number of Pgpool2 log lines matching each log rule since the exporter started",
+		},
+		[]string{"event"},
+	)
+	plannedEvents := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "log_planned_events_total",
+			Help:      "Number of Pgpool2 log lines matching each log rule while a backend node was in maintenance, not counted in pgpool2_log_events_total",
+		},
+		[]string{"event"},
+	)
+	for _, rule := range rules {
+		events.WithLabelValues(rule.Name)
+		plannedEvents.WithLabelValues(rule.Name)
+	}
+	return &LogTailer{
+		path:          path,
+		rules:         rules,
+		interval:      time.Second,
+		planned:       planned,
+		events:        events,
+		plannedEvents: plannedEvents,
+	}
+}
+
+func (t *LogTailer) Describe(ch chan<- *prometheus.Desc) {
+	t.events.Describe(ch)
+	t.plannedEvents.Describe(ch)
+}
+
+func (t *LogTailer) Collect(ch chan<- prometheus.Metric) {
+	t.events.Collect(ch)
+	t.plannedEvents.Collect(ch)
+}
+
+// Run follows the log file forever, reopening it after rotation or truncation.
+// Lines written before the exporter started are not counted.
+func (t *LogTailer) Run() {
+	seekEnd := true
+	for {
+		if err := t.follow(seekEnd); err != nil {
+			logrus.Warnf("Cannot follow log file %s: %v", t.path, err)
+		}
+		seekEnd = false
+		time.Sleep(t.interval)
+	}
+}
+
+func (t *LogTailer) follow(seekEnd bool) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if seekEnd {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+	reader := bufio.NewReader(f)
+	partial := ""
+	rotated := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err == nil {
+			t.match(partial + line)
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		partial += line
+		// drain whatever was written to the old file before returning
+		if rotated {
+			return nil
+		}
+		time.Sleep(t.interval)
+		rotated, err = t.rotated(f)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (t *LogTailer) rotated(f *os.File) (bool, error) {
+	current, err := os.Stat(t.path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	opened, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if !os.SameFile(current, opened) {
+		return true, nil
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	return current.Size() < offset, nil
+}
+
+func (t *LogTailer) match(line string) {
+	line = strings.TrimSpace(line)
+	planned := t.planned != nil && t.planned()
+	for _, rule := range t.rules {
+		if rule.Regexp.MatchString(line) {
+			if planned {
+				t.plannedEvents.WithLabelValues(rule.Name).Inc()
+			} else {
+				t.events.WithLabelValues(rule.Name).Inc()
+			}
+			logrus.WithFields(logrus.Fields{"event": rule.Name, "planned": planned}).Info(line)
+		}
+	}
+}
@@ -1,96 +1,752 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/navcanada/pgpool2-exporter/pgpool2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 	"github.com/prometheus/common/version"
 	"github.com/sirupsen/logrus"
 )
 
 var (
 	showVersion   = flag.Bool("version", false, "Prints version information and exit")
+	configFile    = flag.String("config.file", "", "Path to the optional YAML configuration file")
+	ageIdentity   = flag.String("config.age-identity", "", "Identity file to decrypt an age encrypted configuration file with")
 	metricsPath   = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
-	listenAddress = flag.String("web.listen-address", ":9288", "Address on which to expose metrics and web interface.")
-	pcpPassFile   = flag.String("pcp.passfile", "", "Path to the PCP password file containing hostname:port:username:password")
+	listenAddress = flag.String("web.listen-address", ":9719", "Address on which to expose metrics and web interface.")
+	adminAddress  = flag.String("web.admin-listen-address", "", "Address of the admin interface with debug endpoints, a missing host binds to localhost (disabled if empty)")
+	readOnly      = flag.Bool("read-only", false, "Reject the requests of the admin interface that change pgpool or the exporter, i.e. maintenance marks and online recoveries, and disable /debug/pprof/profile and /debug/pprof/trace; POST /api/v1/scripts stays writable for the reports of the pgpool scripts")
+	pcpPassFile   = flag.String("pcp.passfile", "", "Path to the PCP password file containing hostname:port:username:password, or docker-secret://<name> for /run/secrets/<name>")
 	pcpHostname   = flag.String("pcp.host", "127.0.0.1", "PCP hostname")
 	pcpPort       = flag.Int("pcp.port", 9898, "PCP port")
 	pcpUsername   = flag.String("pcp.username", "pcpadmin", "PCP username")
-	pcpPassword   = flag.String("pcp.password", "", "PCP password")
+	pcpPassword   = flag.String("pcp.password", "", "PCP password, or docker-secret://<name> to read it from /run/secrets/<name>")
+	pcpTimeout    secondsFlag
+	collectMode   = flag.String("collect.mode", CollectModePCP, "How to collect from pgpool: pcp (run the pcp binaries against the PCP port) or sql (SHOW POOL_NODES and friends on the SQL listener given by -sql.dsn, without watchdog metrics)")
+	sqlDSN        = flag.String("sql.dsn", "", "Connection string or postgres:// URL of the pgpool SQL listener for -collect.mode=sql, e.g. 'port=9999 user=monitor dbname=postgres sslmode=disable', the host defaults to that of the target; or docker-secret://<name>")
+	runAsUser     = flag.String("pcp.run-as", "", "OS user to run the pcp commands as, e.g. the pgpool user where only it can read the pcp binaries or password file; requires -pcp.passfile (disabled if empty)")
+	runAsMethod   = flag.String("pcp.run-as-method", RunAsSudo, "How to run the pcp commands as the -pcp.run-as user: sudo (sudo -n -u, needs a sudoers rule with SETENV) or setuid (needs root or CAP_SETUID and CAP_SETGID)")
+	logPath       = flag.String("log.path", "", "Path to the Pgpool2 log file to follow for failover events (disabled if empty)")
+	logRules      logRuleFlag
+	accessLog     = flag.Bool("web.access-log", false, "Log every HTTP request with the client address")
+	proxies       cidrListFlag
+	allowedCIDRs  cidrListFlag
+	metricsCompat = flag.String("metrics.compat", MetricsCompatV0, "Also export metric names from before the naming cleanup: v0 (deprecated names and node_info labels) or none")
+	timeoutOffset = flag.Duration("web.scrape-timeout-offset", 500*time.Millisecond, "Safety margin subtracted from the X-Prometheus-Scrape-Timeout-Seconds header of a scrape")
+	resolveNodes  = flag.Bool("node.resolve-hostnames", false, "Resolve the backend hostnames reported by pcp_node_info on every scrape and export the DNS lookup result")
+	memoryLimit   = flag.String("runtime.memory-limit", "", "Soft memory limit of the Go runtime like GOMEMLIMIT, e.g. 24MiB (default from GOMEMLIMIT, none if unset)")
+	gcPercent     = flag.Int("runtime.gogc", 0, "GC target percentage of the Go runtime like GOGC, negative disables the GC (default from GOGC, 100 if unset)")
+	textfileDir   = flag.String("textfile.directory", "", "Directory of *.prom files to merge into the exported metrics (disabled if empty)")
+	pollInterval  = flag.Duration("collect.interval", 0, "Collect the targets in the background at this interval and serve the last result on the telemetry path (collect on every scrape if 0)")
+	pgpoolTZ      = flag.String("pgpool.timezone", "", "Time zone of pgpool, e.g. Europe/Paris, that the times without zone in the PCP outputs are parsed in (default the local time zone)")
+	clusterMode   = flag.String("pgpool.cluster-mode", ClusterModeAuto, "Clustering mode of Pgpool2: auto (detect with pcp_pool_status), streaming_replication, native_replication, logical_replication, slony, snapshot_isolation or raw; replication metrics are only exported for streaming_replication")
+	failedOrder   = flag.String("collect.failed-order", FailedCollectorsLast, "Run the collectors that failed in the last scrape last, first or in the usual order (none)")
+	pollTimestamp = flag.Bool("collect.timestamps", false, "Attach the collection time as timestamp to the samples collected in the background")
+	cacheTTL      = flag.Duration("collect.cache-ttl", 0, "Serve what a collector collected for this long before running its PCP commands again, for collectors without an interval in collector_intervals (collect on every scrape if 0)")
+	nodeRefresh   = flag.Duration("collect.node-refresh-interval", 0, "Query pcp_node_info of all nodes at most at this interval in the background while pcp_pool_status reports the same backend statuses (query every collection if 0)")
+	nodeWorkers   = flag.Int("collect.max-concurrency", 1, "Number of pcp_node_info commands to run at once, which shortens the scrapes of clusters with many backend nodes")
+	backendDSN    = flag.String("backend.dsn", "", "Connection string or postgres:// URL to query the PostgreSQL version of every backend node with, e.g. 'user=monitor dbname=postgres sslmode=disable', host and port default to those of pcp_node_info; or docker-secret://<name> (disabled if empty)")
+	rolePolls     = flag.Int("node.role-change-polls", 1, "Number of consecutive collections that have to report the new role of a backend node before pgpool2_backend_role_changes_total counts the change")
+	nodeDetail    = flag.String("node.detail", NodeDetailFull, "Detail of the node metrics: basic (count and status), standard (adds weight, role and last status change) or full (adds replication labels, cluster mode and DNS lookups); a scrape can ask for another one with the node_detail parameter")
+	vipAddress    = flag.String("watchdog.vip-address", "", "Delegate IP of the watchdog as host[:port] (default port 9999) to probe with a TCP connect on every scrape (disabled if empty)")
+	recordDir     = flag.String("pcp.record-dir", "", "Directory to store the raw output of every PCP command in, for bug reports (disabled if empty)")
+	replayDir     = flag.String("pcp.replay-dir", "", "Directory of a pcp.record-dir recording to serve PCP output from instead of running the pcp binaries (disabled if empty)")
+	pidFile       = flag.String("process.pid-file", "", "Path to the pid file of a pgpool on the same host, found by a scan of /proc if empty")
+	processStats  = flag.Bool("process.metrics", false, "Export liveness, child processes and restarts of a pgpool on the same host, independent of PCP")
+	cgroupStats   = flag.Bool("process.cgroup", false, "Export the cgroup v2 memory and CPU usage of a pgpool on the same host")
+	listenerAddr  = flag.String("listener.address", "", "host:port of the pgpool frontend listener to probe at an interval (disabled if empty)")
+	listenerUser  = flag.String("listener.user", "", "User to send a startup packet for after connecting to the listener, waiting for the first response (TCP connect only if empty)")
+	listenerPoll  = flag.Duration("listener.interval", 15*time.Second, "Interval of the listener probes")
+	listenerWait  = flag.Duration("listener.timeout", 5*time.Second, "Timeout of a listener probe")
+	updateCheck   = flag.Duration("update.check-interval", 0, "Check for a newer release at this interval, at least 1h, and export pgpool2_exporter_update_available; the exporter never updates itself (disabled if 0)")
+	updateURL     = flag.String("update.releases-url", defaultReleasesURL, "GitHub API URL of the latest release to check against, e.g. of a GitHub Enterprise mirror")
+	probeTargets  = flag.Int("probe.max-module-targets", 100, "Number of targets created by /probe requests with a module to keep, the least recently probed one is removed beyond (unlimited if 0)")
+	dumpMetrics   = flag.String("debug.dump-metrics", "", "Collect metrics once, write them in text format to this file ('-' for stdout) and exit")
 )
 
+func init() {
+	flag.Var(&proxies, "web.trusted-proxies", "Comma separated networks of proxies whose X-Forwarded-For header gives the client address in the access log and request counters")
+	flag.Var(&allowedCIDRs, "web.allow-cidr", "Comma separated networks of the clients allowed on the listen address, all if empty (can be repeated)")
+	flag.Var(&pcpTimeout, "pcp.timeout", "Timeout of every PCP command as a duration like 5s, a bare number is taken as seconds (none if 0, the scrape timeout still applies)")
+	flag.Var(&logRules, "log.rule", "Additional log rule as name=regexp counted in pgpool2_log_events_total (can be repeated)")
+}
+
+// secondsFlag is a duration flag that also takes a bare number of seconds,
+// like the timeout argument of the pcp tools before pgpool 3.5.
+type secondsFlag time.Duration
+
+func (f *secondsFlag) String() string {
+	return time.Duration(*f).String()
+}
+
+func (f *secondsFlag) Set(value string) error {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		*f = secondsFlag(seconds * float64(time.Second))
+	} else {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*f = secondsFlag(d)
+	}
+	if *f < 0 {
+		return fmt.Errorf("negative duration %q", value)
+	}
+	return nil
+}
+
 func versionInfo() {
 	fmt.Println(version.Print(exporterName))
 	os.Exit(0)
 }
 
+// siteGatherers returns the gatherers of the site-specific metrics from
+// commands and textfiles, which are not bound to a target.
+func siteGatherers(ctx context.Context, config *Config) prometheus.Gatherers {
+	gatherers := prometheus.Gatherers{commandsGatherer(ctx, config.Commands)}
+	if len(*textfileDir) != 0 {
+		gatherers = append(gatherers, textfileGatherer{directory: *textfileDir})
+	}
+	return gatherers
+}
+
+// gatherOnce performs a single collection of the targets, commands and
+// textfiles in private registries, leaving out the Go runtime and build info
+// collectors.
+func gatherOnce(targets []*Target, config *Config) ([]*dto.MetricFamily, error) {
+	ctx := context.Background()
+	collected, err := targetsGatherer(ctx, targets)
+	if err != nil {
+		return nil, err
+	}
+	gatherer := append(prometheus.Gatherers{collected}, siteGatherers(ctx, config)...)
+	return newMappingGatherer(newHashingGatherer(gatherer, config.LabelHashing), config.MetricMappings).Gather()
+}
+
+// dumpMetricsOnce performs a single collection of the targets and writes the
+// text exposition to path, so outputs can be compared between versions.
+func dumpMetricsOnce(targets []*Target, config *Config, path string) error {
+	metricFamilies, err := gatherOnce(targets, config)
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		return encodeMetrics(os.Stdout, metricFamilies)
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encodeMetrics(out, metricFamilies); err != nil {
+		out.Close()
+		return err
+	}
+	// a full disk may only show when the file is closed
+	return out.Close()
+}
+
+func encodeMetrics(w io.Writer, metricFamilies []*dto.MetricFamily) error {
+	encoder := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, mf := range metricFamilies {
+		if err := encoder.Encode(mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scrapeTimeout returns the time left for a scrape from the timeout Prometheus
+// sends along, minus the safety margin.
+func scrapeTimeout(r *http.Request, offset time.Duration) (time.Duration, bool) {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if len(header) == 0 {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil {
+		logrus.Warnf("Cannot parse X-Prometheus-Scrape-Timeout-Seconds %q: %v", header, err)
+		return 0, false
+	}
+	timeout := time.Duration(seconds*float64(time.Second)) - offset
+	if timeout <= 0 {
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	return timeout, true
+}
+
+// scrapeContext returns the context of a scrape request, with the deadline
+// derived from the scrape timeout if Prometheus sent one.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if timeout, ok := scrapeTimeout(r, *timeoutOffset); ok {
+		return context.WithTimeout(r.Context(), timeout)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// requestContext returns the context of a scrape request with the node detail
+// the request asks for in the node_detail parameter, if any.
+func requestContext(ctx context.Context, r *http.Request) (context.Context, error) {
+	detail := r.URL.Query().Get("node_detail")
+	if len(detail) == 0 {
+		return ctx, nil
+	}
+	if !isNodeDetail(detail) {
+		return nil, fmt.Errorf("unknown node detail %q", detail)
+	}
+	return WithNodeDetail(ctx, detail), nil
+}
+
+// metricsHandler registers the targets, commands and textfiles per scrape,
+// bound to the scrape deadline, next to the collectors of the default registry.
+// With a background gatherer the targets are served from its last snapshot,
+// and a node_detail parameter is rejected.
+func metricsHandler(targets []*Target, config *Config, background *backgroundGatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := scrapeContext(r)
+		defer cancel()
+		var collected prometheus.Gatherer = background
+		if background != nil && r.URL.Query().Has("node_detail") {
+			// the snapshot was collected with the node.detail of the flag
+			http.Error(w, "node_detail cannot be used with collect.interval", http.StatusBadRequest)
+			return
+		}
+		if background == nil {
+			ctx, err := requestContext(ctx, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			collected, err = targetsGatherer(ctx, targets)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		gatherers := append(prometheus.Gatherers{prometheus.DefaultGatherer, collected}, siteGatherers(ctx, config)...)
+		gatherer := newMappingGatherer(newHashingGatherer(gatherers, config.LabelHashing), config.MetricMappings)
+		promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+func isClusterMode(mode string) bool {
+	if mode == ClusterModeAuto {
+		return true
+	}
+	for _, m := range pgpool2.ClusterModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// adminHandler serves the debug endpoints and the operator APIs, which must
+// not be reachable on the public listen address. In read-only mode the
+// operator APIs only list, and the CPU profile and execution trace, which
+// slow down the exporter while they run, are disabled.
+func adminHandler(targets []*Target, scripts *ScriptReporter, recovery *RecoveryTracker, readOnly bool) http.Handler {
+	mux := http.NewServeMux()
+	var maintenance, recoveries http.Handler = maintenanceAPIHandler(targets), recovery
+	var profile, trace http.Handler = http.HandlerFunc(pprof.Profile), http.HandlerFunc(pprof.Trace)
+	if readOnly {
+		maintenance, recoveries = readOnlyHandler(maintenance), readOnlyHandler(recoveries)
+		profile, trace = disabledHandler(), disabledHandler()
+	}
+	mux.Handle("/api/v1/maintenance", maintenance)
+	// the scripts only report what pgpool did, which read-only mode must
+	// not hide
+	mux.Handle("/api/v1/scripts", scripts)
+	mux.Handle("/api/v1/recovery", recoveries)
+	mux.HandleFunc("/debug/stderr", stderrHandler)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.Handle("/debug/pprof/profile", profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.Handle("/debug/pprof/trace", trace)
+	return mux
+}
+
+// localAddress binds an address without host to localhost.
+func localAddress(address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	if len(host) == 0 {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [check|compat [dir]|config|generate config-schema|init [path]]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Commands:\n  check\tCollect metrics once, lint them and exit non-zero on problems\n  compat [dir]\tRun every PCP command, record its output in dir and report how well it was parsed, for support requests\n  config\tPrint the effective configuration and where each value comes from, with secrets masked\n  generate config-schema\tPrint the JSON Schema of the config file\n  init [path]\tWrite a starter config file (default %s), asking for the target on a terminal\n\nFlags:\n", defaultInitPath)
+		printVisibleDefaults()
+	}
 	flag.Parse()
 
 	if *showVersion == true {
 		versionInfo()
 	}
 
+	if *metricsCompat != MetricsCompatV0 && *metricsCompat != MetricsCompatNone {
+		logrus.Fatalf("Unknown metrics compatibility mode: %s", *metricsCompat)
+	}
+
+	if err := applyRuntimeLimits(*memoryLimit, *gcPercent); err != nil {
+		logrus.Fatal(err)
+	}
+
+	if len(*recordDir) != 0 && filepath.Clean(*recordDir) == filepath.Clean(*replayDir) {
+		logrus.Fatal("pcp.record-dir must not be the replayed directory")
+	}
+
+	if err := validateFaults(); err != nil {
+		logrus.Fatal(err)
+	}
+	if faultsEnabled() {
+		logrus.Warnf("Injecting PCP faults: latency %s, failure ratio %v, malformed ratio %v", *faultLatency, *faultFailures, *faultMalformed)
+	}
+
+	if !isClusterMode(*clusterMode) {
+		logrus.Fatalf("Unknown cluster mode: %s", *clusterMode)
+	}
+
+	if !isNodeDetail(*nodeDetail) {
+		logrus.Fatalf("Unknown node detail: %s", *nodeDetail)
+	}
+
+	if *failedOrder != FailedCollectorsLast && *failedOrder != FailedCollectorsFirst && *failedOrder != FailedCollectorsKeep {
+		logrus.Fatalf("Unknown order of failed collectors: %s", *failedOrder)
+	}
+
+	if *listenerPoll <= 0 || *listenerWait <= 0 {
+		logrus.Fatal("-listener.interval and -listener.timeout must be positive")
+	}
+
+	if *updateCheck != 0 && *updateCheck < time.Hour {
+		logrus.Fatal("-update.check-interval must be at least 1h, to stay within the API rate limits")
+	}
+
+	if *pollInterval < 0 {
+		logrus.Fatalf("Invalid collection interval: %s", *pollInterval)
+	}
+
+	if *pollTimestamp && *pollInterval == 0 {
+		logrus.Fatal("-collect.timestamps requires -collect.interval")
+	}
+
+	switch *collectMode {
+	case CollectModePCP:
+	case CollectModeSQL:
+		if len(*sqlDSN) == 0 {
+			logrus.Fatal("-collect.mode=sql requires -sql.dsn")
+		}
+		if len(*runAsUser) != 0 {
+			logrus.Fatal("-pcp.run-as does not apply to -collect.mode=sql")
+		}
+		dataSource, err := resolveSecret(*sqlDSN)
+		if err != nil {
+			logrus.Fatalf("Cannot read the SQL data source: %v", err)
+		}
+		sqlDataSource = dataSource
+		if _, err := newSQLExecutor(*pcpHostname); err != nil {
+			logrus.Fatalf("Invalid SQL data source: %v", err)
+		}
+	default:
+		logrus.Fatalf("Unknown collection mode: %s", *collectMode)
+	}
+
+	if len(*runAsUser) != 0 {
+		switch *runAsMethod {
+		case RunAsSudo:
+		case RunAsSetuid:
+			credential, err := userCredential(*runAsUser)
+			if err != nil {
+				logrus.Fatalf("Cannot run the pcp commands as %s: %v", *runAsUser, err)
+			}
+			runAsCredential = credential
+		default:
+			logrus.Fatalf("Unknown run as method: %s", *runAsMethod)
+		}
+	}
+
+	if *nodeRefresh < 0 {
+		logrus.Fatalf("Invalid node refresh interval: %s", *nodeRefresh)
+	}
+	if *nodeRefresh > 0 && *pollInterval == 0 {
+		logrus.Fatal("-collect.node-refresh-interval requires -collect.interval")
+	}
+	var backendDataSource string
+	if len(*backendDSN) != 0 {
+		dataSource, err := resolveSecret(*backendDSN)
+		if err != nil {
+			logrus.Fatalf("Cannot read the backend data source: %v", err)
+		}
+		if _, err := newBackendConnector(dataSource, backendAddress{}); err != nil {
+			logrus.Fatalf("Invalid backend data source: %v", err)
+		}
+		backendDataSource = dataSource
+	}
+
+	if *cacheTTL < 0 {
+		logrus.Fatalf("Invalid cache TTL: %s", *cacheTTL)
+	}
+	if *nodeWorkers < 1 {
+		logrus.Fatalf("Invalid maximum concurrency: %d", *nodeWorkers)
+	}
+	if *rolePolls < 1 {
+		logrus.Fatalf("Invalid number of role change polls: %d", *rolePolls)
+	}
+	if *probeTargets < 0 {
+		logrus.Fatalf("Invalid maximum number of module targets: %d", *probeTargets)
+	}
+
+	if strings.Join(flag.Args(), " ") == "generate config-schema" {
+		if err := printConfigSchema(os.Stdout); err != nil {
+			logrus.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	if flag.Arg(0) == "init" && flag.NArg() <= 2 {
+		path := defaultInitPath
+		if flag.NArg() == 2 {
+			path = flag.Arg(1)
+		}
+		if err := runInit(path, os.Stdin, os.Stdout, isTerminal(os.Stdin)); err != nil {
+			logrus.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	isCompat := flag.Arg(0) == "compat" && flag.NArg() <= 2
+	if !isCompat && (flag.NArg() > 1 || (flag.NArg() == 1 && flag.Arg(0) != "check" && flag.Arg(0) != "config")) {
+		logrus.Fatalf("Unknown command: %s", strings.Join(flag.Args(), " "))
+	}
+
+	config, err := LoadConfig(*configFile, *ageIdentity)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	if flag.Arg(0) == "config" {
+		if err := printEffectiveConfig(os.Stdout, config); err != nil {
+			logrus.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
 	errChan := make(chan error, 10)
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
-
-	logrus.Infof("Starting %s %s...", exporterName, version.Version)
-	logrus.Infof("Listen address: %s", *listenAddress)
+	signal.Notify(signalChan, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM}, handOverSignals...)...)
 
+	password, err := resolveSecret(*pcpPassword)
+	if err != nil {
+		logrus.Fatalf("Cannot read the PCP password: %v", err)
+	}
+	passFile, err := resolveSecretPath(*pcpPassFile)
+	if err != nil {
+		logrus.Fatalf("Cannot find the PCP password file: %v", err)
+	}
 	options := pgpool2.Options{
 		Username: *pcpUsername,
-		Password: *pcpPassword,
+		Password: password,
 		Hostname: *pcpHostname,
 		Port:     *pcpPort,
-		PassFile: *pcpPassFile,
+		PassFile: passFile,
+		Timeout:  time.Duration(pcpTimeout),
 	}
 
-	pgpool2Client, err := pgpool2.NewClient(options)
+	if isCompat {
+		dir := "pgpool2-compat-" + time.Now().UTC().Format("20060102T150405Z")
+		if flag.NArg() == 2 {
+			dir = flag.Arg(1)
+		}
+		os.Exit(runCompat(options, dir, os.Stdout))
+	}
+
+	logrus.Infof("Starting %s %s...", exporterName, version.Version)
+	logrus.Infof("Listen address: %s", *listenAddress)
+
+	exporterOptions := ExporterOptions{
+		MetricsCompat:    *metricsCompat,
+		NodeIDs:          config.NodeIDs,
+		ResolveNodes:     *resolveNodes,
+		CollectorWeights: config.CollectorWeights,
+		ClusterMode:      *clusterMode,
+		FailedCollectors: *failedOrder,
+		VIPAddress:       *vipAddress,
+		NodeDetail:       *nodeDetail,
+
+		DatabaseConnectionLimits: config.DatabaseConnectionLimits,
+		CollectorIntervals:       config.CollectorIntervals,
+		CacheTTL:                 *cacheTTL,
+		BackendDSN:               backendDataSource,
+		VersionSource:            VersionSourcePCPTools,
+		NodeInfoConcurrency:      *nodeWorkers,
+		NodeRefreshInterval:      *nodeRefresh,
+		StatementRates:           *pollInterval > 0,
+		RoleChangePolls:          *rolePolls,
+		HealthWeights:            config.HealthWeights,
+		HealthMaxReplicationLag:  config.HealthMaxReplicationLag,
+	}
+	// SHOW POOL_VERSION answers --version in SQL mode
+	if *collectMode == CollectModeSQL {
+		exporterOptions.CollectMode = CollectModeSQL
+		exporterOptions.VersionSource = VersionSourcePgpool
+	}
+	if len(*pgpoolTZ) != 0 {
+		location, err := time.LoadLocation(*pgpoolTZ)
+		if err != nil {
+			logrus.Fatalf("Invalid pgpool time zone: %v", err)
+		}
+		exporterOptions.Timezone = location
+	}
+	// validated with the config file
+	exporterOptions.NodeInfoOverrides, _ = compileNodeInfoOverrides(config.NodeInfoOverrides)
+
+	// the targets from the config file replace the one given by the flags
+	var targets []*Target
+	if len(config.Targets) == 0 {
+		targetExporterOptions := exporterOptions
+		targetExporterOptions.MaintenanceNodes = NewMaintenanceNodes(config.MaintenanceNodes)
+		target, err := NewTarget("", []pgpool2.Options{options}, targetExporterOptions)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		targets = append(targets, target)
+	}
+	for _, targetConfig := range config.Targets {
+		targetExporterOptions := exporterOptions
+		if targetConfig.NodeIDs != nil {
+			targetExporterOptions.NodeIDs = targetConfig.NodeIDs
+		}
+		if len(targetConfig.VIPAddress) != 0 {
+			targetExporterOptions.VIPAddress = targetConfig.VIPAddress
+		}
+		if targetConfig.DatabaseConnectionLimits != nil {
+			targetExporterOptions.DatabaseConnectionLimits = targetConfig.DatabaseConnectionLimits
+		}
+		maintenanceNodes := config.MaintenanceNodes
+		if targetConfig.MaintenanceNodes != nil {
+			maintenanceNodes = targetConfig.MaintenanceNodes
+		}
+		targetExporterOptions.MaintenanceNodes = NewMaintenanceNodes(maintenanceNodes)
+		if len(targetConfig.ClusterMode) != 0 {
+			targetExporterOptions.ClusterMode = targetConfig.ClusterMode
+		}
+		if len(targetConfig.Timezone) != 0 {
+			// validated with the config file
+			targetExporterOptions.Timezone, _ = time.LoadLocation(targetConfig.Timezone)
+		}
+		if targetConfig.NodeInfoOverrides != nil {
+			targetExporterOptions.NodeInfoOverrides, _ = compileNodeInfoOverrides(targetConfig.NodeInfoOverrides)
+		}
+		if len(targetConfig.Version) != 0 {
+			// validated with the config file
+			version, _ := pgpool2.ParseVersionNumber(targetConfig.Version)
+			targetExporterOptions.Version = &version
+			targetExporterOptions.VersionSource = VersionSourceConfig
+		}
+		endpointOptions, err := targetConfig.EndpointOptions(options)
+		if err != nil {
+			cleanTargets(targets)
+			logrus.Fatalf("target %s: %v", targetConfig.Name, err)
+		}
+		target, err := NewTarget(targetConfig.Name, endpointOptions, targetExporterOptions)
+		if err != nil {
+			cleanTargets(targets)
+			logrus.Fatal(err)
+		}
+		for _, endpoint := range target.endpoints {
+			logrus.Infof("Target %s: %s", target.Name, endpoint.address)
+		}
+		targets = append(targets, target)
+	}
+
+	if flag.Arg(0) == "check" {
+		exitCode := runCheck(targets, config)
+		cleanTargets(targets)
+		os.Exit(exitCode)
+	}
+
+	if len(*dumpMetrics) != 0 {
+		err := dumpMetricsOnce(targets, config, *dumpMetrics)
+		cleanTargets(targets)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	prober := NewProber(targets, config, options, exporterOptions, *probeTargets)
+
+	var background *backgroundGatherer
+	if *pollInterval > 0 {
+		logrus.Infof("Collecting in the background every %s", *pollInterval)
+		background = newBackgroundGatherer(targets, *pollInterval, *pollTimestamp)
+		go background.Run()
+	}
+
+	if err := prometheus.Register(newMemoryLimitCollector()); err != nil {
+		errChan <- err
+	}
+	if err := prometheus.Register(selfCollector{}); err != nil {
+		errChan <- err
+	}
+
+	if *processStats || *cgroupStats {
+		if err := prometheus.Register(NewProcessCollector(*pidFile, *processStats, *cgroupStats)); err != nil {
+			errChan <- err
+		}
+	}
+
+	if len(*listenerAddr) != 0 {
+		logrus.Infof("Probing the pgpool listener %s every %s", *listenerAddr, *listenerPoll)
+		listenerProber := NewListenerProber(*listenerAddr, *listenerUser, *listenerPoll, *listenerWait)
+		if err := prometheus.Register(listenerProber); err != nil {
+			errChan <- err
+		}
+		go listenerProber.Run()
+	}
+
+	if *updateCheck != 0 {
+		logrus.Infof("Checking for new releases every %s", *updateCheck)
+		updateChecker := NewUpdateChecker(*updateURL, *updateCheck)
+		if err := prometheus.Register(updateChecker); err != nil {
+			errChan <- err
+		}
+		go updateChecker.Run()
+	}
+
+	if len(*logPath) != 0 {
+		logrus.Infof("Following log file: %s", *logPath)
+		logTailer := NewLogTailer(*logPath, mergeLogRules(logRules), func() bool {
+			return anyMaintenance(targets)
+		})
+		if err := prometheus.Register(logTailer); err != nil {
+			errChan <- err
+		}
+		go logTailer.Run()
+	}
+
+	// net/http/pprof registers itself on the default mux, so the public
+	// endpoints get their own
+	mux := http.NewServeMux()
+	mux.Handle(*metricsPath, metricsHandler(targets, config, background))
+	mux.Handle("/probe", prober)
+	mux.Handle("/api/v1/targets", targetsAPIHandler(targets, prober))
+	mux.Handle("/rules", rulesHandler())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html>
+			<head><title>` + exporterName + ` v` + version.Version + `</title></head>
+			<body>
+			<h1>` + exporterName + ` v` + version.Version + `</h1>
+			<p><a href='` + *metricsPath + `'>Metrics</a></p>
+			<p><a href='/api/v1/targets'>Targets</a></p>
+			<p><a href='/rules'>Rules</a></p>
+			</body>
+			</html>
+		`))
+	})
+
+	// listeners handed over by the previous process on SIGUSR2 come first
+	inherited, err := inheritedListeners()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	listener, err := listen(inherited, 0, *listenAddress)
 	if err != nil {
 		logrus.Fatal(err)
 	}
+	listeners := []net.Listener{listener}
+	handler := newAccessHandler(mux, proxies, allowedCIDRs, *accessLog)
+	if err := prometheus.Register(handler); err != nil {
+		errChan <- err
+	}
+	servers := []*http.Server{{Handler: handler}}
+	if len(*adminAddress) != 0 {
+		address, err := localAddress(*adminAddress)
+		if err != nil {
+			logrus.Fatalf("Invalid admin listen address %s: %v", *adminAddress, err)
+		}
+		logrus.Infof("Admin listen address: %s", address)
+		if *readOnly {
+			logrus.Info("Read-only mode, the admin interface rejects maintenance marks, online recoveries, CPU profiles and traces")
+		}
+		adminListener, err := listen(inherited, 1, address)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		listeners = append(listeners, adminListener)
+		scripts := NewScriptReporter()
+		if err := prometheus.Register(scripts); err != nil {
+			errChan <- err
+		}
+		recovery := NewRecoveryTracker(targets)
+		if err := prometheus.Register(recovery); err != nil {
+			errChan <- err
+		}
+		servers = append(servers, &http.Server{Handler: adminHandler(targets, scripts, recovery, *readOnly)})
+	}
+	// e.g. the admin listener after the admin interface was disabled
+	for i := len(listeners); i < len(inherited); i++ {
+		inherited[i].Close()
+	}
 
 	go func() {
 		for {
 			select {
 			case err := <-errChan:
 				if err != nil {
-					pgpool2Client.Clean()
+					cleanTargets(targets)
+					prober.Clean()
 					logrus.Fatal(err)
 				}
 			case signal := <-signalChan:
-				logrus.Infof("Captured %v. Exiting...", signal)
-				pgpool2Client.Clean()
+				if isHandOverSignal(signal) {
+					process, err := handOver(listeners)
+					if err != nil {
+						logrus.Errorf("Cannot hand over to a new process: %v", err)
+						continue
+					}
+					logrus.Infof("Handed over listeners to process %d, finishing running requests...", process.Pid)
+					shutdownServers(servers)
+				} else {
+					logrus.Infof("Captured %v. Exiting...", signal)
+				}
+				cleanTargets(targets)
+				prober.Clean()
 				logrus.Info("Bye")
 				os.Exit(0)
 			}
 		}
 	}()
 
-	exporter := NewExporter(pgpool2Client)
-	if err := prometheus.Register(exporter); err != nil {
-		errChan <- err
+	for i := range servers {
+		go func(server *http.Server, listener net.Listener) {
+			if err := server.Serve(listener); err != http.ErrServerClosed {
+				errChan <- err
+			}
+		}(servers[i], listeners[i])
 	}
-
-	http.Handle(*metricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-			<head><title>` + exporterName + ` v` + version.Version + `</title></head>
-			<body>
-			<h1>` + exporterName + ` v` + version.Version + `</h1>
-			<p><a href='` + *metricsPath + `'>Metrics</a></p>
-			</body>
-			</html>
-		`))
-	})
-
-	errChan <- http.ListenAndServe(*listenAddress, nil)
+	// the signal handler exits the process
+	select {}
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MaintenanceNodes are the backend node ids of a target that operators marked
+// as in maintenance, from the config file or the admin API. A nil
+// MaintenanceNodes has no node in maintenance.
+type MaintenanceNodes struct {
+	mutex sync.Mutex
+	ids   map[int]bool
+}
+
+func NewMaintenanceNodes(ids []int) *MaintenanceNodes {
+	m := &MaintenanceNodes{ids: make(map[int]bool)}
+	for _, id := range ids {
+		m.ids[id] = true
+	}
+	return m
+}
+
+// Set marks the node as in maintenance or clears the mark.
+func (m *MaintenanceNodes) Set(id int, maintenance bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if maintenance {
+		m.ids[id] = true
+	} else {
+		delete(m.ids, id)
+	}
+}
+
+// IDs returns the node ids in maintenance in ascending order.
+func (m *MaintenanceNodes) IDs() []int {
+	if m == nil {
+		return nil
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ids := make([]int, 0, len(m.ids))
+	for id := range m.ids {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// anyMaintenance reports whether a node of any target is in maintenance.
+func anyMaintenance(targets []*Target) bool {
+	for _, target := range targets {
+		if len(target.maintenance.IDs()) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type apiMaintenance struct {
+	Target string `json:"target"`
+	Nodes  []int  `json:"nodes"`
+}
+
+// maintenanceAPIHandler lists the nodes in maintenance on GET, marks the node
+// given by the node and target parameters on PUT and clears the mark on
+// DELETE. Marks set here are lost on restart, the config file keeps them.
+func maintenanceAPIHandler(targets []*Target) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodDelete:
+			target := findTarget(targets, r.URL.Query().Get("target"))
+			if target == nil {
+				http.Error(w, fmt.Sprintf("Unknown target %q", r.URL.Query().Get("target")), http.StatusBadRequest)
+				return
+			}
+			id, err := strconv.Atoi(r.URL.Query().Get("node"))
+			if err != nil || id < 0 {
+				http.Error(w, fmt.Sprintf("Invalid node id %q", r.URL.Query().Get("node")), http.StatusBadRequest)
+				return
+			}
+			maintenance := r.Method == http.MethodPut
+			target.maintenance.Set(id, maintenance)
+			logrus.WithField("target", target.Name).Infof("Node %d in maintenance: %t", id, maintenance)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		result := make([]apiMaintenance, 0, len(targets))
+		for _, target := range targets {
+			result = append(result, apiMaintenance{
+				Target: target.Name,
+				Nodes:  target.maintenance.IDs(),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(apiResponse{
+			Status: "success",
+			Data: map[string]interface{}{
+				"maintenance": result,
+			},
+		})
+		if err != nil {
+			logrus.Errorf("Cannot write maintenance API response: %v", err)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// mappingGatherer applies the configured metric mappings to everything the
+// wrapped gatherer returns.
+type mappingGatherer struct {
+	gatherer prometheus.Gatherer
+	mappings map[string]MetricMapping
+}
+
+func newMappingGatherer(gatherer prometheus.Gatherer, mappings []MetricMapping) prometheus.Gatherer {
+	if len(mappings) == 0 {
+		return gatherer
+	}
+	g := &mappingGatherer{
+		gatherer: gatherer,
+		mappings: make(map[string]MetricMapping),
+	}
+	for _, mapping := range mappings {
+		g.mappings[mapping.Metric] = mapping
+	}
+	return g
+}
+
+func (g *mappingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	metricFamilies, err := g.gatherer.Gather()
+	var errs prometheus.MultiError
+	if err != nil {
+		errs = append(errs, err)
+	}
+	result := make([]*dto.MetricFamily, 0, len(metricFamilies))
+	names := make(map[string]bool)
+	for _, mf := range metricFamilies {
+		if mapping, ok := g.mappings[mf.GetName()]; ok {
+			if mapping.Drop {
+				continue
+			}
+			if err := applyMetricMapping(mf, mapping); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		if names[mf.GetName()] {
+			errs = append(errs, fmt.Errorf("metric mapping results in duplicate metric family %s", mf.GetName()))
+			continue
+		}
+		sortMetrics(mf)
+		names[mf.GetName()] = true
+		result = append(result, mf)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetName() < result[j].GetName()
+	})
+	return result, errs.MaybeUnwrap()
+}
+
+func applyMetricMapping(mf *dto.MetricFamily, mapping MetricMapping) error {
+	if len(mapping.Rename) != 0 {
+		mf.Name = proto.String(mapping.Rename)
+	}
+	dropLabels := make(map[string]bool)
+	for _, name := range mapping.DropLabels {
+		dropLabels[name] = true
+	}
+	series := make(map[string]bool)
+	for _, m := range mf.GetMetric() {
+		labels := make([]*dto.LabelPair, 0, len(m.GetLabel()))
+		labelNames := make(map[string]bool)
+		for _, label := range m.GetLabel() {
+			if dropLabels[label.GetName()] {
+				continue
+			}
+			if to, ok := mapping.Labels[label.GetName()]; ok {
+				label.Name = proto.String(to)
+			}
+			if labelNames[label.GetName()] {
+				return fmt.Errorf("metric mapping results in duplicate label %s for metric %s", label.GetName(), mf.GetName())
+			}
+			labelNames[label.GetName()] = true
+			labels = append(labels, label)
+		}
+		sort.Slice(labels, func(i, j int) bool {
+			return labels[i].GetName() < labels[j].GetName()
+		})
+		m.Label = labels
+		signature := make([]string, 0, len(labels))
+		for _, label := range labels {
+			signature = append(signature, label.GetName()+"="+label.GetValue())
+		}
+		key := strings.Join(signature, "\xff")
+		if series[key] {
+			return fmt.Errorf("metric mapping results in duplicate series for metric %s", mf.GetName())
+		}
+		series[key] = true
+	}
+	return nil
+}
+
+// sortMetrics sorts the series of the family by their label values in the
+// order of the label names, like the registry does, so that the exposition
+// stays the same across scrapes after labels were renamed or rewritten.
+func sortMetrics(mf *dto.MetricFamily) {
+	sort.SliceStable(mf.Metric, func(i, j int) bool {
+		labelsI, labelsJ := mf.Metric[i].GetLabel(), mf.Metric[j].GetLabel()
+		for k := 0; k < len(labelsI) && k < len(labelsJ); k++ {
+			if labelsI[k].GetName() != labelsJ[k].GetName() {
+				return labelsI[k].GetName() < labelsJ[k].GetName()
+			}
+			if labelsI[k].GetValue() != labelsJ[k].GetValue() {
+				return labelsI[k].GetValue() < labelsJ[k].GetValue()
+			}
+		}
+		if len(labelsI) != len(labelsJ) {
+			return len(labelsI) < len(labelsJ)
+		}
+		return mf.Metric[i].GetTimestampMs() < mf.Metric[j].GetTimestampMs()
+	})
+}
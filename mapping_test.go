@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// textGatherer gathers the metric families of a text exposition, sorted by
+// name like a registry does.
+func textGatherer(tb testing.TB, text string) prometheus.Gatherer {
+	tb.Helper()
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(text))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		result := make([]*dto.MetricFamily, 0, len(families))
+		for _, mf := range families {
+			result = append(result, mf)
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].GetName() < result[j].GetName()
+		})
+		return result, nil
+	})
+}
+
+// exposition returns the gathered metric families in the text format.
+func exposition(tb testing.TB, metricFamilies []*dto.MetricFamily) string {
+	tb.Helper()
+	var out bytes.Buffer
+	for _, mf := range metricFamilies {
+		if _, err := expfmt.MetricFamilyToText(&out, mf); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return out.String()
+}
+
+const mappingInput = `# TYPE pgpool2_frontend_used gauge
+pgpool2_frontend_used{database="db",username="alice"} 2
+pgpool2_frontend_used{database="db",username="bob"} 1
+# TYPE pgpool2_up gauge
+pgpool2_up 1
+`
+
+func TestMappingGatherer(t *testing.T) {
+	tests := []struct {
+		name     string
+		mappings []MetricMapping
+		want     string
+		wantErr  string
+	}{
+		{
+			name: "no mappings",
+			want: mappingInput,
+		},
+		{
+			name:     "drop",
+			mappings: []MetricMapping{{Metric: "pgpool2_frontend_used", Drop: true}},
+			want: `# TYPE pgpool2_up gauge
+pgpool2_up 1
+`,
+		},
+		{
+			name: "rename metric and label",
+			mappings: []MetricMapping{{
+				Metric: "pgpool2_frontend_used",
+				Rename: "site_pool_clients",
+				Labels: map[string]string{"username": "user"},
+			}},
+			want: `# TYPE pgpool2_up gauge
+pgpool2_up 1
+# TYPE site_pool_clients gauge
+site_pool_clients{database="db",user="alice"} 2
+site_pool_clients{database="db",user="bob"} 1
+`,
+		},
+		{
+			name:     "drop label",
+			mappings: []MetricMapping{{Metric: "pgpool2_frontend_used", DropLabels: []string{"database"}}},
+			want: `# TYPE pgpool2_frontend_used gauge
+pgpool2_frontend_used{username="alice"} 2
+pgpool2_frontend_used{username="bob"} 1
+# TYPE pgpool2_up gauge
+pgpool2_up 1
+`,
+		},
+		{
+			name:     "duplicate series",
+			mappings: []MetricMapping{{Metric: "pgpool2_frontend_used", DropLabels: []string{"username"}}},
+			wantErr:  "duplicate series",
+		},
+		{
+			name:     "duplicate label",
+			mappings: []MetricMapping{{Metric: "pgpool2_frontend_used", Labels: map[string]string{"username": "database"}}},
+			wantErr:  "duplicate label database",
+		},
+		{
+			name:     "duplicate family",
+			mappings: []MetricMapping{{Metric: "pgpool2_frontend_used", Rename: "pgpool2_up"}},
+			wantErr:  "duplicate metric family pgpool2_up",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newMappingGatherer(textGatherer(t, mappingInput), tt.mappings).Gather()
+			if len(tt.wantErr) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text := exposition(t, got); text != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", text, tt.want)
+			}
+		})
+	}
+}
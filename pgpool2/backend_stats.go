@@ -0,0 +1,90 @@
+package pgpool2
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// SQLBackendStats is SHOW POOL_BACKEND_STATS (pgpool 4.2+), which has no pcp
+// tool: only SQLExecutor answers it.
+const SQLBackendStats = "pool_backend_stats"
+
+// statement types of SHOW POOL_BACKEND_STATS by "Key : value" line
+var backendStatsKeys = map[string]string{
+	"Select Count": "select",
+	"Insert Count": "insert",
+	"Update Count": "update",
+	"Delete Count": "delete",
+	"DDL Count":    "ddl",
+	"Other Count":  "other",
+	"Panic Count":  "panic",
+	"Fatal Count":  "fatal",
+	"Error Count":  "error",
+}
+
+// BackendStats are the statement counters of a backend node. The JSON and
+// YAML field names are part of the API.
+type BackendStats struct {
+	NodeID   int    `json:"nodeId" yaml:"nodeId"`
+	Hostname string `json:"hostname" yaml:"hostname"`
+	// Statements are the statements sent to the node since pgpool started
+	// by type, e.g. select or ddl, and the panic, fatal and error
+	// messages it returned
+	Statements map[string]uint64 `json:"statements" yaml:"statements"`
+}
+
+func (c *Client) ExecBackendStatsContext(ctx context.Context) ([]BackendStats, error) {
+	var stats []BackendStats
+	err := c.execCommand(ctx, func(r io.Reader) (err error) {
+		stats, err = BackendStatsUnmarshal(r)
+		return err
+	}, SQLBackendStats)
+	if err != nil {
+		return []BackendStats{}, err
+	}
+	return stats, nil
+}
+
+// BackendStatsUnmarshal parses the output of SQLExecutor for SQLBackendStats,
+// where every node is a block of "Key : value" lines starting with "Node ID".
+func BackendStatsUnmarshal(cmdOutBuff io.Reader) ([]BackendStats, error) {
+	var stats []BackendStats
+	reader := getReader(cmdOutBuff)
+	defer putReader(reader)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				break
+			} else {
+				return stats, err
+			}
+		}
+		line = strings.TrimSpace(line)
+		key := pcpKey(line)
+		if key == "Node ID" {
+			id, err := strconv.Atoi(ExtractValueFromPCPString(line))
+			if err != nil {
+				continue
+			}
+			stats = append(stats, BackendStats{NodeID: id, Statements: make(map[string]uint64)})
+			continue
+		}
+		if len(stats) == 0 {
+			continue
+		}
+		node := &stats[len(stats)-1]
+		if key == "Hostname" {
+			node.Hostname = ExtractValueFromPCPString(line)
+			continue
+		}
+		if statement, ok := backendStatsKeys[key]; ok {
+			if count, err := strconv.ParseUint(ExtractValueFromPCPString(line), 10, 64); err == nil {
+				node.Statements[statement] = count
+			}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,42 @@
+package pgpool2
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestBackendStatsUnmarshal(t *testing.T) {
+	data := []byte(`Node ID      : 0
+Hostname     : 10.0.0.11
+Select Count : 81234
+Insert Count : 512
+Update Count : 230
+Delete Count : 17
+DDL Count    : 2
+Other Count  : 4410
+Panic Count  : 0
+Fatal Count  : 1
+Error Count  : 9
+
+Node ID      : 1
+Hostname     : 10.0.0.12
+Select Count : 79110
+Insert Count : 
+`)
+	want := []BackendStats{
+		{NodeID: 0, Hostname: "10.0.0.11", Statements: map[string]uint64{
+			"select": 81234, "insert": 512, "update": 230, "delete": 17, "ddl": 2,
+			"other": 4410, "panic": 0, "fatal": 1, "error": 9,
+		}},
+		// a column older versions do not report is left out
+		{NodeID: 1, Hostname: "10.0.0.12", Statements: map[string]uint64{"select": 79110}},
+	}
+	got, err := BackendStatsUnmarshal(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
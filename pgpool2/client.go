@@ -1,17 +1,17 @@
 package pgpool2
 
 import (
-	"bufio"
-	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -28,6 +28,23 @@ const (
 	NodeStatusDown           = "Node is down"
 	NodeStatusUnknown        = "Unknown node status"
 
+	// child process status reported by pcp_proc_info since pgpool 4.2
+	ProcStatusWaitForConnection = "Wait for connection"
+
+	// backend_clustering_mode values of pgpool 4.2+, older versions use
+	// master_slave_mode, master_slave_sub_mode and replication_mode
+	ClusterModeStreamingReplication = "streaming_replication"
+	ClusterModeNativeReplication    = "native_replication"
+	ClusterModeLogicalReplication   = "logical_replication"
+	ClusterModeSlony                = "slony"
+	ClusterModeSnapshotIsolation    = "snapshot_isolation"
+	ClusterModeRaw                  = "raw"
+
+	// units of the replication delay, pgpool 4.3+ reports it in seconds
+	// with delay_threshold_by_time
+	ReplicationDelayBytes   = "bytes"
+	ReplicationDelaySeconds = "seconds"
+
 	// do not reorder
 	// https://github.com/pgpool/pgpool2/blob/master/src/tools/pcp/pcp_frontend_client.c#L624
 	QuorumStateUnknown      = -3
@@ -38,6 +55,11 @@ const (
 )
 
 var (
+	// PCPValueRegExp describes the "Key : value" lines of PCP output, its
+	// capture group is the value.
+	//
+	// Deprecated: use ExtractValueFromPCPString, or NodeInfoOverrides to
+	// change how a field of pcp_node_info is found.
 	PCPValueRegExp = regexp.MustCompile(`^[^:]+: (.*)$`)
 
 	nodeStatusToString = map[int]string{
@@ -47,6 +69,16 @@ var (
 		3: NodeStatusDown,
 	}
 
+	// ClusterModes are the known clustering modes
+	ClusterModes = []string{
+		ClusterModeStreamingReplication,
+		ClusterModeNativeReplication,
+		ClusterModeLogicalReplication,
+		ClusterModeSlony,
+		ClusterModeSnapshotIsolation,
+		ClusterModeRaw,
+	}
+
 	quorumStateToInt = map[string]int{
 		"UNKNOWN":               QuorumStateUnknown,
 		"NO MASTER NODE":        QuorumStateNoMasterNode,
@@ -62,21 +94,105 @@ type Options struct {
 	Port     int
 	Username string
 	Password string
+	// Timeout bounds every PCP command on top of its context, none if 0
+	Timeout time.Duration
+}
+
+// ValidationError lists every problem found by Options.Validate.
+type ValidationError []error
+
+func (e ValidationError) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validate checks the options without running any PCP command, so they can be
+// checked before creating a client. It reports all problems at once as a
+// ValidationError.
+func (o Options) Validate() error {
+	var errs ValidationError
+	if len(o.Hostname) == 0 {
+		errs = append(errs, errors.New("PCP hostname must be specified"))
+	}
+	if len(o.Username) == 0 {
+		errs = append(errs, errors.New("PCP username must be specified"))
+	}
+	if o.Port <= 0 {
+		errs = append(errs, errors.New("PCP port must be greater than zero"))
+	}
+	if o.Timeout < 0 {
+		errs = append(errs, errors.New("PCP timeout must not be negative"))
+	}
+	if len(o.PassFile) != 0 {
+		if err := validatePassFile(o.PassFile); err != nil {
+			errs = append(errs, err)
+		}
+	} else if len(o.Password) == 0 {
+		errs = append(errs, errors.New("PCP password (or pcppass file) must be specified"))
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func validatePassFile(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("pcppass %s does not exist", path)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot retrieve file mode from `Stat`: %v", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("pcppass must be a file")
+	}
+	if info.Mode() != os.FileMode(0600) {
+		return fmt.Errorf("unexpected file mode for '%s': %s", path, info.Mode().String())
+	}
+	return nil
 }
 
 type Client struct {
 	options         Options
-	pcpPassFile     string
+	executor        Executor
 	pcpPassFileUser bool
-	pcpPassTempFile *os.File
+	// nodeInfoOverrides replace how fields of pcp_node_info are parsed
+	nodeInfoOverrides NodeInfoOverrides
+	// version is the known pgpool version, nil to detect it
+	version *Version
+
+	// passFileMutex guards the managed password file, which CheckPassFile
+	// may replace while commands run
+	passFileMutex       sync.Mutex
+	pcpPassFile         string
+	pcpPassTempFile     *os.File
+	passFileRecreations int
+	passFileFailures    int
 }
 
 func NewClient(options Options) (*Client, error) {
+	return New(WithOptions(options))
+}
+
+// New creates a client configured by opts, e.g.
+//
+//	New(WithHost("pgpool.example.com"), WithUsername("pcpadmin"), WithPassFile("/etc/pcppass"))
+func New(opts ...ClientOption) (*Client, error) {
 	client := &Client{
-		options: options,
+		executor: ExecExecutor{},
+	}
+	for _, opt := range opts {
+		opt(client)
 	}
-	if len(options.PassFile) != 0 {
-		client.pcpPassFile = options.PassFile
+	if client.executor == nil {
+		client.executor = ExecExecutor{}
+	}
+	if len(client.options.PassFile) != 0 {
+		client.pcpPassFile = client.options.PassFile
 		client.pcpPassFileUser = true
 	}
 	if err := client.Validate(); err != nil {
@@ -88,21 +204,19 @@ func NewClient(options Options) (*Client, error) {
 	return client, nil
 }
 
+func (c *Client) Options() Options {
+	return c.options
+}
+
 func (c *Client) createPCPTempFile() error {
 	if c.pcpPassFileUser {
 		return nil
 	}
-	f, err := ioutil.TempFile("", "pgpool2")
+	f, err := os.CreateTemp("", "pgpool2")
 	if err != nil {
 		return err
 	}
-	_, err = f.WriteString(fmt.Sprintf(
-		"%s:%d:%s:%s",
-		c.options.Hostname,
-		c.options.Port,
-		c.options.Username,
-		c.options.Password,
-	))
+	_, err = f.WriteString(c.passFileContent())
 	if err != nil {
 		return err
 	}
@@ -115,50 +229,88 @@ func (c *Client) createPCPTempFile() error {
 	return nil
 }
 
+func (c *Client) passFileContent() string {
+	return fmt.Sprintf(
+		"%s:%d:%s:%s",
+		c.options.Hostname,
+		c.options.Port,
+		c.options.Username,
+		c.options.Password,
+	)
+}
+
+// CheckPassFile verifies that the password file the client manages still
+// exists with mode 0600 and the expected content, and recreates it otherwise,
+// e.g. after a tmp cleaner removed it. A user supplied passfile is validated
+// like on creation of the client but left alone.
+func (c *Client) CheckPassFile() error {
+	c.passFileMutex.Lock()
+	defer c.passFileMutex.Unlock()
+	if c.pcpPassFileUser {
+		if err := validatePassFile(c.pcpPassFile); err != nil {
+			c.passFileFailures++
+			return err
+		}
+		return nil
+	}
+	info, err := os.Stat(c.pcpPassFile)
+	if err == nil && info.Mode() == os.FileMode(0600) {
+		content, err := os.ReadFile(c.pcpPassFile)
+		if err == nil && string(content) == c.passFileContent() {
+			return nil
+		}
+	}
+	c.passFileFailures++
+	previous := c.pcpPassTempFile
+	if err := c.createPCPTempFile(); err != nil {
+		return fmt.Errorf("cannot recreate pcppass: %v", err)
+	}
+	if previous != nil {
+		previous.Close()
+		os.Remove(previous.Name())
+	}
+	c.passFileRecreations++
+	return nil
+}
+
+// PassFileRecreations returns how often CheckPassFile recreated the password
+// file.
+func (c *Client) PassFileRecreations() int {
+	c.passFileMutex.Lock()
+	defer c.passFileMutex.Unlock()
+	return c.passFileRecreations
+}
+
+// PassFileFailures returns how often CheckPassFile found the password file
+// missing, modified or with another mode than 0600.
+func (c *Client) PassFileFailures() int {
+	c.passFileMutex.Lock()
+	defer c.passFileMutex.Unlock()
+	return c.passFileFailures
+}
+
 func (c *Client) Clean() error {
 	if c.pcpPassFileUser {
 		return nil
 	}
+	c.passFileMutex.Lock()
+	defer c.passFileMutex.Unlock()
 	if c.pcpPassTempFile == nil {
 		return nil
 	}
+	c.pcpPassTempFile.Close()
 	err := os.Remove(c.pcpPassTempFile.Name())
 	return err
 }
 
+// Validate checks the client options, see Options.Validate.
 func (c *Client) Validate() error {
-	if len(c.options.Hostname) == 0 {
-		return errors.New("PCP hostname must be specified")
-	}
-	if len(c.options.Username) == 0 {
-		return errors.New("PCP username must be specified")
-	}
-	if c.options.Port <= 0 {
-		return errors.New("PCP port must be greater than zero")
-	}
-	if len(c.pcpPassFile) != 0 {
-		info, err := os.Stat(c.pcpPassFile)
-		if os.IsNotExist(err) {
-			return fmt.Errorf("pcppass %s does not exist", c.pcpPassFile)
-		}
-		if err != nil {
-			return fmt.Errorf("cannot retrieve file mode from `Stat`: %v", err)
-		}
-		if info.IsDir() {
-			return fmt.Errorf("pcppass must be a file")
-		}
-		if info.Mode() != os.FileMode(0600) {
-			return fmt.Errorf("unexpected file mode for '%s': %s", c.pcpPassFile, info.Mode().String())
-		}
-		c.pcpPassFileUser = true
-	} else if len(c.options.Password) == 0 {
-		return errors.New("PCP password (or pcppass file) must be specified")
-	}
-	return nil
+	return c.options.Validate()
 }
 
-func (c *Client) execCommand(cmd string, arg ...string) (*bytes.Buffer, error) {
-	stdoutBuffer := &bytes.Buffer{}
+// execCommand runs a PCP command with the executor of the client and streams
+// its stdout into parse.
+func (c *Client) execCommand(ctx context.Context, parse func(io.Reader) error, cmd string, arg ...string) error {
 	argCommon := []string{
 		fmt.Sprintf("--username=%s", c.options.Username),
 		fmt.Sprintf("--host=%s", c.options.Hostname),
@@ -167,28 +319,38 @@ func (c *Client) execCommand(cmd string, arg ...string) (*bytes.Buffer, error) {
 		"--no-password",
 	}
 	argResult := append(argCommon, arg...)
-	pgpoolExec := exec.Command(cmd, argResult...)
-	pgpoolExec.Env = []string{
-		fmt.Sprintf("PCPPASSFILE=%s", c.pcpPassFile),
+	if c.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.Timeout)
+		defer cancel()
 	}
-	pgpoolExec.Stdout = stdoutBuffer
-	err := pgpoolExec.Run()
-	if err != nil {
-		return stdoutBuffer, err
+	c.passFileMutex.Lock()
+	env := []string{
+		fmt.Sprintf("PCPPASSFILE=%s", c.pcpPassFile),
 	}
-	return stdoutBuffer, nil
+	c.passFileMutex.Unlock()
+	return c.executor.Exec(ctx, parse, cmd, argResult, env)
 }
 
+// ExecNodeCount is ExecNodeCountContext with the background context, only
+// Options.Timeout ends a hung command.
+//
+// Deprecated: use ExecNodeCountContext.
 func (c *Client) ExecNodeCount() (int, error) {
-	bytesBuffer, err := c.execCommand(PCPNodeCount)
-	if err != nil {
-		return 0, err
-	}
-	bytes, err := ioutil.ReadAll(bytesBuffer)
+	return c.ExecNodeCountContext(context.Background())
+}
+
+func (c *Client) ExecNodeCountContext(ctx context.Context) (int, error) {
+	output := getBuffer()
+	defer putBuffer(output)
+	err := c.execCommand(ctx, func(r io.Reader) error {
+		_, err := output.ReadFrom(r)
+		return err
+	}, PCPNodeCount)
 	if err != nil {
 		return 0, err
 	}
-	resultString := strings.TrimSpace(string(bytes))
+	resultString := strings.TrimSpace(output.String())
 	if len(resultString) == 0 {
 		return 0, nil
 	}
@@ -199,17 +361,22 @@ func (c *Client) ExecNodeCount() (int, error) {
 	return resultInt, nil
 }
 
+// NodeInfo is the state of one backend node. The JSON and YAML field names are
+// part of the API.
 type NodeInfo struct {
-	Hostname             string
-	Port                 int
-	StatusCode           int
-	Status               string
-	Weight               float64
-	Role                 string
-	ReplicationDelay     float64
-	ReplicationState     string
-	ReplicationSyncState string
-	LastStatusChange     string
+	Hostname         string  `json:"hostname" yaml:"hostname"`
+	Port             int     `json:"port" yaml:"port"`
+	StatusCode       int     `json:"statusCode" yaml:"statusCode"`
+	Status           string  `json:"status" yaml:"status"`
+	Weight           float64 `json:"weight" yaml:"weight"`
+	Role             string  `json:"role" yaml:"role"`
+	ReplicationDelay float64 `json:"replicationDelay" yaml:"replicationDelay"`
+	// ReplicationDelayUnit is ReplicationDelayBytes or ReplicationDelaySeconds,
+	// empty if pgpool reported no delay
+	ReplicationDelayUnit string `json:"replicationDelayUnit,omitempty" yaml:"replicationDelayUnit,omitempty"`
+	ReplicationState     string `json:"replicationState" yaml:"replicationState"`
+	ReplicationSyncState string `json:"replicationSyncState" yaml:"replicationSyncState"`
+	LastStatusChange     string `json:"lastStatusChange" yaml:"lastStatusChange"`
 }
 
 func NodeStatusCodeToString(statusID int) string {
@@ -220,17 +387,111 @@ func NodeStatusCodeToString(statusID int) string {
 	return status
 }
 
+// ExtractValueFromPCPString returns the value of a "Key : value" line, which is
+// everything after the first colon and the following space. It runs on every
+// line of outputs with thousands of lines, so it does without a regexp.
 func ExtractValueFromPCPString(line string) string {
-	valueArr := PCPValueRegExp.FindStringSubmatch(line)
-	if len(valueArr) > 0 {
-		return valueArr[1]
+	i := strings.IndexByte(line, ':')
+	if i <= 0 || i+1 >= len(line) || line[i+1] != ' ' || strings.IndexByte(line, '\n') >= 0 {
+		return ""
 	}
-	return ""
+	return line[i+2:]
+}
+
+// NodeInfoOverrides replace how fields of pcp_node_info output are found, by
+// field name of NodeInfoFields. An overridden field is taken from the first
+// capture group of its regexp on any line, instead of the "Key : value" line
+// of the field.
+type NodeInfoOverrides map[string]*regexp.Regexp
+
+type nodeInfoField struct {
+	name string
+	// key is the name of the "Key : value" line of the field
+	key string
+	// set ignores values that do not parse, like pcp_node_info always did
+	set func(ni *NodeInfo, value string)
+}
+
+// Keys are matched whole, "Role" is not the "Backend Role" of pgpool 4.3+,
+// which is the role PostgreSQL reports and can differ during a failover.
+var nodeInfoFields = []nodeInfoField{
+	{name: "hostname", key: "Hostname", set: func(ni *NodeInfo, value string) {
+		ni.Hostname = value
+	}},
+	{name: "port", key: "Port", set: func(ni *NodeInfo, value string) {
+		if port, err := strconv.Atoi(value); err == nil {
+			ni.Port = port
+		}
+	}},
+	{name: "last_status_change", key: "Last Status Change", set: func(ni *NodeInfo, value string) {
+		ni.LastStatusChange = value
+	}},
+	{name: "status", key: "Status", set: func(ni *NodeInfo, value string) {
+		if status, err := strconv.Atoi(value); err == nil {
+			ni.StatusCode = status
+			ni.Status = NodeStatusCodeToString(status)
+		}
+	}},
+	{name: "weight", key: "Weight", set: func(ni *NodeInfo, value string) {
+		if weight, err := strconv.ParseFloat(value, 64); err == nil {
+			ni.Weight = weight
+		}
+	}},
+	{name: "role", key: "Role", set: func(ni *NodeInfo, value string) {
+		ni.Role = value
+	}},
+	{name: "replication_delay", key: "Replication Delay", set: func(ni *NodeInfo, value string) {
+		// e.g. "1024", or "2 second" with delay_threshold_by_time
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return
+		}
+		if delay, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			ni.ReplicationDelay = delay
+			ni.ReplicationDelayUnit = ReplicationDelayBytes
+			if len(fields) > 1 && strings.HasPrefix(fields[1], "sec") {
+				ni.ReplicationDelayUnit = ReplicationDelaySeconds
+			}
+		}
+	}},
+	{name: "replication_state", key: "Replication State", set: func(ni *NodeInfo, value string) {
+		ni.ReplicationState = value
+	}},
+	{name: "replication_sync_state", key: "Replication Sync State", set: func(ni *NodeInfo, value string) {
+		ni.ReplicationSyncState = value
+	}},
+}
+
+// NodeInfoFields returns the names of the fields NodeInfoOverrides can
+// replace.
+func NodeInfoFields() []string {
+	names := make([]string, 0, len(nodeInfoFields))
+	for _, field := range nodeInfoFields {
+		names = append(names, field.name)
+	}
+	return names
+}
+
+// NodeInfoKeys returns the keys of the "Key : value" lines of pcp_node_info
+// that NodeInfoUnmarshal reads.
+func NodeInfoKeys() []string {
+	keys := make([]string, 0, len(nodeInfoFields))
+	for _, field := range nodeInfoFields {
+		keys = append(keys, field.key)
+	}
+	return keys
 }
 
 func NodeInfoUnmarshal(cmdOutBuff io.Reader) (NodeInfo, error) {
+	return NodeInfoUnmarshalWith(cmdOutBuff, nil)
+}
+
+// NodeInfoUnmarshalWith parses pcp_node_info output like NodeInfoUnmarshal,
+// with the fields in overrides found by their regexp.
+func NodeInfoUnmarshalWith(cmdOutBuff io.Reader, overrides NodeInfoOverrides) (NodeInfo, error) {
 	var ni NodeInfo
-	reader := bufio.NewReader(cmdOutBuff)
+	reader := getReader(cmdOutBuff)
+	defer putReader(reader)
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
@@ -241,92 +502,111 @@ func NodeInfoUnmarshal(cmdOutBuff io.Reader) (NodeInfo, error) {
 			}
 		}
 		line = strings.TrimSpace(line)
-		if strings.Contains(line, "Hostname") {
-			ni.Hostname = ExtractValueFromPCPString(line)
-		}
-		if strings.Contains(line, "Port") {
-			portRaw := ExtractValueFromPCPString(line)
-			portInt, err := strconv.Atoi(portRaw)
-			if err != nil {
-				continue
-			}
-			ni.Port = portInt
-		}
-		// this has to be tested before "Status"
-		if strings.Contains(line, "Last Status Change") {
-			ni.LastStatusChange = ExtractValueFromPCPString(line)
-		}
-		if strings.Contains(line, "Status") {
-			statusRaw := ExtractValueFromPCPString(line)
-			statusInt, err := strconv.Atoi(statusRaw)
-			if err != nil {
+		for _, field := range nodeInfoFields {
+			if re, ok := overrides[field.name]; ok {
+				if match := re.FindStringSubmatch(line); len(match) > 1 {
+					field.set(&ni, match[1])
+				}
 				continue
 			}
-			ni.StatusCode = statusInt
-			ni.Status = NodeStatusCodeToString(statusInt)
-		}
-		if strings.Contains(line, "Weight") {
-			weightRaw := ExtractValueFromPCPString(line)
-			weightFloat, err := strconv.ParseFloat(weightRaw, 64)
-			if err != nil {
-				continue
-			}
-			ni.Weight = weightFloat
-		}
-		if strings.Contains(line, "Role") {
-			ni.Role = ExtractValueFromPCPString(line)
-		}
-		if strings.Contains(line, "Replication Delay") {
-			delayRaw := ExtractValueFromPCPString(line)
-			delayFloat, err := strconv.ParseFloat(delayRaw, 64)
-			if err != nil {
-				continue
+			if pcpKey(line) == field.key {
+				field.set(&ni, ExtractValueFromPCPString(line))
 			}
-			ni.ReplicationDelay = delayFloat
-		}
-		if strings.Contains(line, "Replication State") {
-			ni.ReplicationState = ExtractValueFromPCPString(line)
-		}
-		if strings.Contains(line, "Replication Sync State") {
-			ni.ReplicationSyncState = ExtractValueFromPCPString(line)
 		}
 	}
 	return ni, nil
 }
 
+// ExecNodeInfo is ExecNodeInfoContext with the background context, only
+// Options.Timeout ends a hung command.
+//
+// Deprecated: use ExecNodeInfoContext.
 func (c *Client) ExecNodeInfo(nodeID int) (NodeInfo, error) {
-	bytesBuffer, err := c.execCommand(PCPNodeInfo, fmt.Sprintf("--node-id=%d", nodeID), "-v")
-	if err != nil {
-		return NodeInfo{}, err
-	}
-	nodeInfo, err := NodeInfoUnmarshal(bytesBuffer)
+	return c.ExecNodeInfoContext(context.Background(), nodeID)
+}
+
+func (c *Client) ExecNodeInfoContext(ctx context.Context, nodeID int) (NodeInfo, error) {
+	var nodeInfo NodeInfo
+	err := c.execCommand(ctx, func(r io.Reader) (err error) {
+		nodeInfo, err = NodeInfoUnmarshalWith(r, c.nodeInfoOverrides)
+		return err
+	}, PCPNodeInfo, fmt.Sprintf("--node-id=%d", nodeID), "-v")
 	if err != nil {
 		return NodeInfo{}, err
 	}
 	return nodeInfo, nil
 }
 
+// ExecProcInfo is ExecProcInfoContext with the background context, only
+// Options.Timeout ends a hung command.
+//
+// Deprecated: use ExecProcInfoContext.
 func (c *Client) ExecProcInfo() ([]ProcInfo, error) {
-	bytesBuffer, err := c.execCommand(PCPProcInfo, "--all")
-	if err != nil {
-		return []ProcInfo{}, err
-	}
-	procInfoArr, err := ProcInfoUnmarshal(bytesBuffer)
+	return c.ExecProcInfoContext(context.Background())
+}
+
+func (c *Client) ExecProcInfoContext(ctx context.Context) ([]ProcInfo, error) {
+	var procInfoArr []ProcInfo
+	err := c.execCommand(ctx, func(r io.Reader) (err error) {
+		procInfoArr, err = ProcInfoUnmarshal(r)
+		return err
+	}, PCPProcInfo, "--all", "-v")
 	if err != nil {
 		return []ProcInfo{}, err
 	}
 	return procInfoArr, nil
 }
 
+// ProcInfoSummary aggregates the connection slots of the child processes per
+// database. The JSON and YAML field names are part of the API; in JSON an
+// unknown FreeChildren is null. It is not safe for concurrent use, share a
+// ProcInfoAggregate instead.
 type ProcInfoSummary struct {
-	Active   map[string]int
-	Inactive map[string]int
+	Active   map[string]int `json:"active" yaml:"active"`
+	Inactive map[string]int `json:"inactive" yaml:"inactive"`
+	// MaxIdleDuration is the longest client idle duration in seconds per database
+	MaxIdleDuration map[string]int `json:"maxIdleSeconds" yaml:"maxIdleSeconds"`
+	// FreeChildren is the number of children waiting for a client connection,
+	// -1 when pgpool does not report process status (before 4.2)
+	FreeChildren int `json:"freeChildren" yaml:"freeChildren"`
+}
+
+// procInfoSummaryJSON is ProcInfoSummary without its JSON methods.
+type procInfoSummaryJSON ProcInfoSummary
+
+func (p ProcInfoSummary) MarshalJSON() ([]byte, error) {
+	var freeChildren *int
+	if p.FreeChildren >= 0 {
+		freeChildren = &p.FreeChildren
+	}
+	return json.Marshal(struct {
+		procInfoSummaryJSON
+		FreeChildren *int `json:"freeChildren"`
+	}{procInfoSummaryJSON(p), freeChildren})
+}
+
+func (p *ProcInfoSummary) UnmarshalJSON(data []byte) error {
+	var v struct {
+		procInfoSummaryJSON
+		FreeChildren *int `json:"freeChildren"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*p = ProcInfoSummary(v.procInfoSummaryJSON)
+	p.FreeChildren = -1
+	if v.FreeChildren != nil {
+		p.FreeChildren = *v.FreeChildren
+	}
+	return nil
 }
 
 func NewProcInfoSummary() ProcInfoSummary {
 	return ProcInfoSummary{
-		Active:   make(map[string]int),
-		Inactive: make(map[string]int),
+		Active:          make(map[string]int),
+		Inactive:        make(map[string]int),
+		MaxIdleDuration: make(map[string]int),
+		FreeChildren:    -1,
 	}
 }
 
@@ -347,46 +627,168 @@ func (p *ProcInfoSummary) Add(database string, active bool) {
 }
 
 func (c *Client) ProcInfoSummary(pi []ProcInfo) ProcInfoSummary {
+	return SummarizeProcInfo(pi)
+}
+
+// SummarizeProcInfo aggregates the connection slots reported by pcp_proc_info.
+func SummarizeProcInfo(pi []ProcInfo) ProcInfoSummary {
 	summary := NewProcInfoSummary()
+	// every child is listed once per connection slot
+	freeChildren := make(map[int]bool)
 	for _, procInfo := range pi {
 		summary.Add(procInfo.Database, procInfo.Connected)
+		if len(procInfo.Status) == 0 {
+			continue
+		}
+		if procInfo.Connected {
+			idle, ok := summary.MaxIdleDuration[procInfo.Database]
+			if !ok || procInfo.ClientIdleDuration > idle {
+				summary.MaxIdleDuration[procInfo.Database] = procInfo.ClientIdleDuration
+			}
+		}
+		if procInfo.Status == ProcStatusWaitForConnection {
+			freeChildren[procInfo.PID] = true
+		}
+		summary.FreeChildren = len(freeChildren)
 	}
 	return summary
 }
 
-func (c *Client) ExecProcCount() ([]string, error) {
-	bytesBuffer, err := c.execCommand(PCPProcCount)
-	if err != nil {
-		return []string{}, err
+// Copy returns a deep copy of the summary.
+func (p ProcInfoSummary) Copy() ProcInfoSummary {
+	summary := ProcInfoSummary{
+		Active:          make(map[string]int, len(p.Active)),
+		Inactive:        make(map[string]int, len(p.Inactive)),
+		MaxIdleDuration: make(map[string]int, len(p.MaxIdleDuration)),
+		FreeChildren:    p.FreeChildren,
+	}
+	for database, count := range p.Active {
+		summary.Active[database] = count
+	}
+	for database, count := range p.Inactive {
+		summary.Inactive[database] = count
 	}
-	bytes, err := ioutil.ReadAll(bytesBuffer)
+	for database, seconds := range p.MaxIdleDuration {
+		summary.MaxIdleDuration[database] = seconds
+	}
+	return summary
+}
+
+// ProcInfoAggregate is a ProcInfoSummary that is safe for concurrent use, e.g.
+// updated by a background poller while HTTP handlers read it.
+type ProcInfoAggregate struct {
+	mutex   sync.Mutex
+	summary ProcInfoSummary
+}
+
+func NewProcInfoAggregate() *ProcInfoAggregate {
+	return &ProcInfoAggregate{summary: NewProcInfoSummary()}
+}
+
+// Add counts one connection slot of database.
+func (a *ProcInfoAggregate) Add(database string, active bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.summary.Add(database, active)
+}
+
+// Update replaces the aggregate with the summary of pi.
+func (a *ProcInfoAggregate) Update(pi []ProcInfo) {
+	summary := SummarizeProcInfo(pi)
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.summary = summary
+}
+
+// Snapshot returns a copy of the current summary, which the caller may keep
+// and modify.
+func (a *ProcInfoAggregate) Snapshot() ProcInfoSummary {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.summary.Copy()
+}
+
+// ExecProcCount is ExecProcCountContext with the background context, only
+// Options.Timeout ends a hung command.
+//
+// Deprecated: use ExecProcCountContext.
+func (c *Client) ExecProcCount() ([]string, error) {
+	return c.ExecProcCountContext(context.Background())
+}
+
+func (c *Client) ExecProcCountContext(ctx context.Context) ([]string, error) {
+	output := getBuffer()
+	defer putBuffer(output)
+	err := c.execCommand(ctx, func(r io.Reader) error {
+		_, err := output.ReadFrom(r)
+		return err
+	}, PCPProcCount)
 	if err != nil {
 		return []string{}, err
 	}
-	procCountString := strings.TrimSpace(string(bytes))
+	procCountString := strings.TrimSpace(output.String())
 	procCountArr := strings.Split(procCountString, " ")
 	return procCountArr, nil
 }
 
+// ExecWatchdogInfo is ExecWatchdogInfoContext with the background context, only
+// Options.Timeout ends a hung command.
+//
+// Deprecated: use ExecWatchdogInfoContext.
 func (c *Client) ExecWatchdogInfo() (WatchdogInfo, error) {
-	bytesBuffer, err := c.execCommand(PCPWatchdogInfo, "-v")
-	if err != nil {
-		return WatchdogInfo{}, err
-	}
-	watchdogInfo, err := WatchdogInfoUnmarshal(bytesBuffer)
+	return c.ExecWatchdogInfoContext(context.Background())
+}
+
+func (c *Client) ExecWatchdogInfoContext(ctx context.Context) (WatchdogInfo, error) {
+	var watchdogInfo WatchdogInfo
+	err := c.execCommand(ctx, func(r io.Reader) (err error) {
+		watchdogInfo, err = WatchdogInfoUnmarshal(r)
+		return err
+	}, PCPWatchdogInfo, "-v")
 	if err != nil {
 		return WatchdogInfo{}, err
 	}
 	return watchdogInfo, nil
 }
 
+// WatchdogInfo is the watchdog cluster state. The JSON and YAML field names are
+// part of the API.
 type WatchdogInfo struct {
-	TotalNodes       int
-	RemoteNodes      int
-	QuorumState      string
-	QuorumStateCode  int
-	AliveRemoteNodes int
-	VIP              bool
+	TotalNodes       int    `json:"totalNodes" yaml:"totalNodes"`
+	RemoteNodes      int    `json:"remoteNodes" yaml:"remoteNodes"`
+	QuorumState      string `json:"quorumState" yaml:"quorumState"`
+	QuorumStateCode  int    `json:"quorumStateCode" yaml:"quorumStateCode"`
+	AliveRemoteNodes int    `json:"aliveRemoteNodes" yaml:"aliveRemoteNodes"`
+	VIP              bool   `json:"vip" yaml:"vip"`
+	// MemberRemoteNodes and QuorumNodesRequired are only reported by pgpool
+	// 4.3+, they are 0 on older versions.
+	MemberRemoteNodes   int `json:"memberRemoteNodes" yaml:"memberRemoteNodes"`
+	QuorumNodesRequired int `json:"quorumNodesRequired" yaml:"quorumNodesRequired"`
+	// Nodes are the watchdog members, the local node first.
+	Nodes []WatchdogNode `json:"nodes" yaml:"nodes"`
+}
+
+// WatchdogNode is one watchdog member of the verbose pcp_watchdog_info
+// output.
+type WatchdogNode struct {
+	Name       string `json:"name" yaml:"name"`
+	Hostname   string `json:"hostname" yaml:"hostname"`
+	StatusCode int    `json:"statusCode" yaml:"statusCode"`
+	Status     string `json:"status" yaml:"status"`
+	// Priority is the wd_priority of the node, the highest one is elected
+	// leader
+	Priority int `json:"priority" yaml:"priority"`
+	// Membership is MEMBER for nodes counted for the quorum (pgpool 4.3+)
+	Membership string `json:"membership" yaml:"membership"`
+}
+
+// pcpKey returns the key of a "Key : value" line without padding.
+func pcpKey(line string) string {
+	i := strings.IndexByte(line, ':')
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(line[:i])
 }
 
 func QuorumStateToCode(state string) int {
@@ -398,7 +800,8 @@ func QuorumStateToCode(state string) int {
 
 func WatchdogInfoUnmarshal(cmdOutBuff io.Reader) (WatchdogInfo, error) {
 	var wi WatchdogInfo
-	reader := bufio.NewReader(cmdOutBuff)
+	reader := getReader(cmdOutBuff)
+	defer putReader(reader)
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
@@ -409,6 +812,52 @@ func WatchdogInfoUnmarshal(cmdOutBuff io.Reader) (WatchdogInfo, error) {
 			}
 		}
 		line = strings.TrimSpace(line)
+		// every member starts with its node name, the lines of the
+		// cluster come before
+		switch pcpKey(line) {
+		case "Node Name":
+			wi.Nodes = append(wi.Nodes, WatchdogNode{Name: ExtractValueFromPCPString(line)})
+			continue
+		case "Host Name":
+			if len(wi.Nodes) != 0 {
+				wi.Nodes[len(wi.Nodes)-1].Hostname = ExtractValueFromPCPString(line)
+			}
+			continue
+		case "Status":
+			if len(wi.Nodes) != 0 {
+				if status, err := strconv.Atoi(ExtractValueFromPCPString(line)); err == nil {
+					wi.Nodes[len(wi.Nodes)-1].StatusCode = status
+				}
+			}
+			continue
+		case "Status Name":
+			if len(wi.Nodes) != 0 {
+				wi.Nodes[len(wi.Nodes)-1].Status = ExtractValueFromPCPString(line)
+			}
+			continue
+		case "Node priority":
+			if len(wi.Nodes) != 0 {
+				if priority, err := strconv.Atoi(ExtractValueFromPCPString(line)); err == nil {
+					wi.Nodes[len(wi.Nodes)-1].Priority = priority
+				}
+			}
+			continue
+		case "Membership Status":
+			if len(wi.Nodes) != 0 {
+				wi.Nodes[len(wi.Nodes)-1].Membership = ExtractValueFromPCPString(line)
+			}
+			continue
+		case "Member Remote Nodes":
+			if n, err := strconv.Atoi(ExtractValueFromPCPString(line)); err == nil {
+				wi.MemberRemoteNodes = n
+			}
+			continue
+		case "Nodes required for quorum":
+			if n, err := strconv.Atoi(ExtractValueFromPCPString(line)); err == nil {
+				wi.QuorumNodesRequired = n
+			}
+			continue
+		}
 		if strings.Contains(line, "Total Nodes") {
 			totalNodesRaw := ExtractValueFromPCPString(line)
 			totalNodesInt, err := strconv.Atoi(totalNodesRaw)
@@ -417,7 +866,8 @@ func WatchdogInfoUnmarshal(cmdOutBuff io.Reader) (WatchdogInfo, error) {
 			}
 			wi.TotalNodes = totalNodesInt
 		}
-		if strings.Contains(line, "Remote Nodes") {
+		// not "Alive Remote Nodes" or "Member Remote Nodes"
+		if pcpKey(line) == "Remote Nodes" {
 			remoteNodesRaw := ExtractValueFromPCPString(line)
 			remoteNodesInt, err := strconv.Atoi(remoteNodesRaw)
 			if err != nil {
@@ -447,15 +897,73 @@ func WatchdogInfoUnmarshal(cmdOutBuff io.Reader) (WatchdogInfo, error) {
 	return wi, nil
 }
 
+// ProcInfo is one connection slot of a child process. The JSON and YAML field
+// names are part of the API.
 type ProcInfo struct {
-	Database  string
-	Username  string
-	Connected bool
+	Database  string `json:"database" yaml:"database"`
+	Username  string `json:"username" yaml:"username"`
+	PID       int    `json:"pid" yaml:"pid"`
+	Connected bool   `json:"connected" yaml:"connected"`
+	// ClientIdleDuration and Status are only reported by pgpool 4.2+;
+	// Status is empty on older versions.
+	ClientIdleDuration int    `json:"clientIdleSeconds" yaml:"clientIdleSeconds"`
+	Status             string `json:"status" yaml:"status"`
+	// ClientConnectionTime is in the local time of pgpool, see
+	// ParseTimestamp, and empty if no client is connected
+	ClientConnectionTime string `json:"clientConnectionTime" yaml:"clientConnectionTime"`
 }
 
+// TimestampLayout is the format of the times in the PCP outputs, which are in
+// the local time of pgpool without a zone.
+const TimestampLayout = "2006-01-02 15:04:05"
+
+// ParseTimestamp parses a time of a PCP output in the time zone of pgpool.
+// A note after the time, like the "(2:59 before process restarting)" of the
+// start time in pcp_proc_info, is ignored.
+func ParseTimestamp(value string, location *time.Location) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) > len(TimestampLayout) {
+		value = value[:len(TimestampLayout)]
+	}
+	t, err := time.ParseInLocation(TimestampLayout, value, location)
+	return t, err == nil
+}
+
+// legacyProcInfoFields is the number of fields of a connection slot line in
+// the output of pcp_proc_info without -v before pgpool 4.2: database,
+// username, start time, creation time, major, minor, pool counter, backend
+// pid, connected, pid and backend id, where both times are a date and a time.
+const legacyProcInfoFields = 13
+
+// legacyProcInfo parses a connection slot line of the output of pcp_proc_info
+// without -v before pgpool 4.2. The times and the empty fields of a slot
+// without backend connection collapse, so only its last three fields are
+// known then.
+func legacyProcInfo(line string) (ProcInfo, bool) {
+	fields := strings.Fields(line)
+	n := len(fields)
+	if n < 5 || n > legacyProcInfoFields {
+		return ProcInfo{}, false
+	}
+	pid, err := strconv.Atoi(fields[n-2])
+	if err != nil {
+		return ProcInfo{}, false
+	}
+	procInfo := ProcInfo{PID: pid, Connected: fields[n-3] == "1"}
+	if n == legacyProcInfoFields {
+		procInfo.Database = fields[0]
+		procInfo.Username = fields[1]
+	}
+	return procInfo, true
+}
+
+// ProcInfoUnmarshal parses the verbose output of pcp_proc_info, where every
+// connection slot is a block of "Key : value" lines starting with "Database",
+// and the output without -v of pgpool before 4.2, a line per slot.
 func ProcInfoUnmarshal(cmdOutBuff io.Reader) ([]ProcInfo, error) {
 	var pi []ProcInfo
-	reader := bufio.NewReader(cmdOutBuff)
+	reader := getReader(cmdOutBuff)
+	defer putReader(reader)
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
@@ -466,16 +974,50 @@ func ProcInfoUnmarshal(cmdOutBuff io.Reader) ([]ProcInfo, error) {
 			}
 		}
 		line = strings.TrimSpace(line)
-		connectionInfo := strings.Split(line, " ")
-		if len(connectionInfo) == 13 {
-			procInfo := ProcInfo{
-				Database: connectionInfo[0],
-				Username: connectionInfo[1],
+		// the times of a line without -v have colons, but no " :"
+		if len(line) != 0 && !strings.Contains(line, " :") {
+			if procInfo, ok := legacyProcInfo(line); ok {
+				pi = append(pi, procInfo)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "Database") {
+			pi = append(pi, ProcInfo{
+				Database: ExtractValueFromPCPString(line),
+			})
+			continue
+		}
+		if len(pi) == 0 {
+			continue
+		}
+		procInfo := &pi[len(pi)-1]
+		if strings.HasPrefix(line, "Username") {
+			procInfo.Username = ExtractValueFromPCPString(line)
+		}
+		if strings.HasPrefix(line, "PID") {
+			pidInt, err := strconv.Atoi(ExtractValueFromPCPString(line))
+			if err != nil {
+				continue
 			}
-			if connectionInfo[12] == "1" {
+			procInfo.PID = pidInt
+		}
+		if strings.HasPrefix(line, "Connected") {
+			if ExtractValueFromPCPString(line) == "1" {
 				procInfo.Connected = true
 			}
-			pi = append(pi, procInfo)
+		}
+		if strings.HasPrefix(line, "Client connection time") {
+			procInfo.ClientConnectionTime = ExtractValueFromPCPString(line)
+		}
+		if strings.HasPrefix(line, "Client idle duration") {
+			idleInt, err := strconv.Atoi(ExtractValueFromPCPString(line))
+			if err != nil {
+				continue
+			}
+			procInfo.ClientIdleDuration = idleInt
+		}
+		if strings.HasPrefix(line, "Status") {
+			procInfo.Status = ExtractValueFromPCPString(line)
 		}
 	}
 	return pi, nil
@@ -0,0 +1,340 @@
+package pgpool2
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// readFixture returns a pcp output captured from pgpool in testdata.
+func readFixture(tb testing.TB, name string) []byte {
+	tb.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return data
+}
+
+// largeProcInfo is the pcp_proc_info -v output of a pgpool with 128 times the
+// connection slots of the fixture, like num_init_children 128 and max_pool 4.
+func largeProcInfo(tb testing.TB) []byte {
+	return bytes.Repeat(readFixture(tb, "pcp_proc_info_4.2.txt"), 128)
+}
+
+func BenchmarkExtractValueFromPCPString(b *testing.B) {
+	lines := strings.Split(string(largeProcInfo(b)), "\n")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, line := range lines {
+			ExtractValueFromPCPString(line)
+		}
+	}
+}
+
+func BenchmarkProcInfoUnmarshal(b *testing.B) {
+	data := largeProcInfo(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ProcInfoUnmarshal(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// truncate cuts a fixture right after the first occurrence of until, like the
+// output of a command killed while writing it.
+func truncate(tb testing.TB, data []byte, until string) []byte {
+	i := bytes.Index(data, []byte(until))
+	if i < 0 {
+		tb.Fatalf("fixture has no %q", until)
+	}
+	return data[:i+len(until)]
+}
+
+func TestProcInfoUnmarshal(t *testing.T) {
+	idle := ProcInfo{Database: "app", Username: "app_user", PID: 21860, Connected: true,
+		ClientIdleDuration: 12, Status: "Idle", ClientConnectionTime: "2021-06-14 09:20:11"}
+	waiting := ProcInfo{PID: 21861, Status: ProcStatusWaitForConnection}
+	tests := []struct {
+		name string
+		data []byte
+		want []ProcInfo
+	}{
+		{
+			name: "4.2 verbose",
+			data: readFixture(t, "pcp_proc_info_4.2.txt"),
+			want: []ProcInfo{idle, idle, waiting, waiting},
+		},
+		{
+			name: "4.1 verbose",
+			data: readFixture(t, "pcp_proc_info_4.1.txt"),
+			want: []ProcInfo{
+				{Database: "app", Username: "app_user", PID: 8290, Connected: true},
+				{PID: 8291},
+			},
+		},
+		{
+			name: "4.1 without -v",
+			data: readFixture(t, "pcp_proc_info_4.1_legacy.txt"),
+			want: []ProcInfo{
+				{Database: "app", Username: "app_user", PID: 8290, Connected: true},
+				{Database: "app", Username: "report", PID: 8292},
+				{PID: 8291},
+			},
+		},
+		{
+			// the unfinished last line is dropped
+			name: "truncated",
+			data: truncate(t, readFixture(t, "pcp_proc_info_4.2.txt"), "Backend PID               : 21875\nConnected  "),
+			want: []ProcInfo{idle, {Database: "app", Username: "app_user", ClientIdleDuration: 12,
+				ClientConnectionTime: "2021-06-14 09:20:11"}},
+		},
+		{
+			name: "empty",
+			data: nil,
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ProcInfoUnmarshal(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeProcInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		want    ProcInfoSummary
+		json    string
+	}{
+		{
+			name:    "4.2",
+			fixture: "pcp_proc_info_4.2.txt",
+			want: ProcInfoSummary{
+				Active:          map[string]int{"app": 2},
+				Inactive:        map[string]int{"": 2},
+				MaxIdleDuration: map[string]int{"app": 12},
+				FreeChildren:    1,
+			},
+			json: `{"active":{"app":2},"inactive":{"":2},"maxIdleSeconds":{"app":12},"freeChildren":1}`,
+		},
+		{
+			// no process status before 4.2, so the free children are unknown
+			name:    "4.1",
+			fixture: "pcp_proc_info_4.1.txt",
+			want: ProcInfoSummary{
+				Active:          map[string]int{"app": 1},
+				Inactive:        map[string]int{"": 1},
+				MaxIdleDuration: map[string]int{},
+				FreeChildren:    -1,
+			},
+			json: `{"active":{"app":1},"inactive":{"":1},"maxIdleSeconds":{},"freeChildren":null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pi, err := ProcInfoUnmarshal(bytes.NewReader(readFixture(t, tt.fixture)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			summary := SummarizeProcInfo(pi)
+			if !reflect.DeepEqual(summary, tt.want) {
+				t.Errorf("got %+v, want %+v", summary, tt.want)
+			}
+			data, err := json.Marshal(summary)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.json {
+				t.Errorf("got JSON %s, want %s", data, tt.json)
+			}
+			var decoded ProcInfoSummary
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, tt.want) {
+				t.Errorf("got %+v from JSON, want %+v", decoded, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeInfoUnmarshalWith(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		overrides NodeInfoOverrides
+		want      NodeInfo
+	}{
+		{
+			name: "4.0",
+			data: readFixture(t, "pcp_node_info_4.0.txt"),
+			want: NodeInfo{Hostname: "10.0.0.11", Port: 5432, StatusCode: 2, Status: NodeStatusUP2,
+				Weight: 0.5, Role: "primary", ReplicationDelayUnit: ReplicationDelayBytes,
+				LastStatusChange: "2020-03-09 14:02:51"},
+		},
+		{
+			name: "4.2",
+			data: readFixture(t, "pcp_node_info_4.2.txt"),
+			want: NodeInfo{Hostname: "10.0.0.12", Port: 5432, StatusCode: 2, Status: NodeStatusUP2,
+				Weight: 0.5, Role: "standby", ReplicationDelay: 1024, ReplicationDelayUnit: ReplicationDelayBytes,
+				ReplicationState: "streaming", ReplicationSyncState: "async",
+				LastStatusChange: "2021-06-14 09:12:40"},
+		},
+		{
+			// the role of pgpool, not the Backend Role of PostgreSQL
+			name: "4.3",
+			data: readFixture(t, "pcp_node_info_4.3.txt"),
+			want: NodeInfo{Hostname: "10.0.0.12", Port: 5432, StatusCode: 3, Status: NodeStatusDown,
+				Weight: 0.5, Role: "standby", ReplicationDelay: 2, ReplicationDelayUnit: ReplicationDelaySeconds,
+				LastStatusChange: "2022-09-05 22:31:07"},
+		},
+		{
+			name: "overrides",
+			data: readFixture(t, "pcp_node_info_4.3.txt"),
+			overrides: NodeInfoOverrides{
+				"role":     regexp.MustCompile(`^Backend Role\s*:\s*(\S+)`),
+				"hostname": regexp.MustCompile(`^Hostname\s*:\s*10\.0\.0\.(\d+)`),
+			},
+			want: NodeInfo{Hostname: "12", Port: 5432, StatusCode: 3, Status: NodeStatusDown,
+				Weight: 0.5, Role: "primary", ReplicationDelay: 2, ReplicationDelayUnit: ReplicationDelaySeconds,
+				LastStatusChange: "2022-09-05 22:31:07"},
+		},
+		{
+			// a field whose regexp matches no line keeps its zero value
+			name:      "override without match",
+			data:      readFixture(t, "pcp_node_info_4.2.txt"),
+			overrides: NodeInfoOverrides{"weight": regexp.MustCompile(`^Load Balance Weight : (.*)`)},
+			want: NodeInfo{Hostname: "10.0.0.12", Port: 5432, StatusCode: 2, Status: NodeStatusUP2,
+				Role: "standby", ReplicationDelay: 1024, ReplicationDelayUnit: ReplicationDelayBytes,
+				ReplicationState: "streaming", ReplicationSyncState: "async",
+				LastStatusChange: "2021-06-14 09:12:40"},
+		},
+		{
+			name: "truncated",
+			data: truncate(t, readFixture(t, "pcp_node_info_4.2.txt"), "Weight                 : 0.5"),
+			want: NodeInfo{Hostname: "10.0.0.12", Port: 5432, StatusCode: 2, Status: NodeStatusUP2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NodeInfoUnmarshalWith(bytes.NewReader(tt.data), tt.overrides)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchdogInfoUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		want    WatchdogInfo
+		// membership of the nodes in order
+		membership []string
+	}{
+		{
+			name:    "4.2",
+			fixture: "pcp_watchdog_info_4.2.txt",
+			want: WatchdogInfo{TotalNodes: 3, RemoteNodes: 2, AliveRemoteNodes: 1, VIP: true,
+				QuorumState: "QUORUM IS ON THE EDGE", QuorumStateCode: QuorumStateOnEdge},
+			membership: []string{"", "", ""},
+		},
+		{
+			name:    "4.3",
+			fixture: "pcp_watchdog_info_4.3.txt",
+			want: WatchdogInfo{TotalNodes: 3, RemoteNodes: 2, AliveRemoteNodes: 1,
+				MemberRemoteNodes: 1, QuorumNodesRequired: 2,
+				QuorumState: "QUORUM EXIST", QuorumStateCode: QuorumStateExist},
+			membership: []string{"MEMBER", "MEMBER", "NOT-MEMBER"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := WatchdogInfoUnmarshal(bytes.NewReader(readFixture(t, tt.fixture)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var membership []string
+			for _, node := range got.Nodes {
+				membership = append(membership, node.Membership)
+			}
+			if !reflect.DeepEqual(membership, tt.membership) {
+				t.Errorf("got membership %q, want %q", membership, tt.membership)
+			}
+			got.Nodes = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchdogInfoUnmarshalNodes(t *testing.T) {
+	pg1 := WatchdogNode{Name: "pg1:9999 Linux pg1", Hostname: "pg1", StatusCode: 4, Status: "LEADER", Priority: 3, Membership: "MEMBER"}
+	pg2 := WatchdogNode{Name: "pg2:9999 Linux pg2", Hostname: "pg2", StatusCode: 7, Status: "STANDBY", Priority: 2, Membership: "MEMBER"}
+	pg3 := WatchdogNode{Name: "pg3:9999 Linux pg3", Hostname: "pg3", StatusCode: 8, Status: "LOST", Priority: 1, Membership: "NOT-MEMBER"}
+	tests := []struct {
+		name string
+		data []byte
+		want []WatchdogNode
+	}{
+		{
+			name: "4.2",
+			data: readFixture(t, "pcp_watchdog_info_4.2.txt"),
+			want: []WatchdogNode{
+				{Name: "pg1:9999 Linux pg1", Hostname: "pg1", StatusCode: 4, Status: "MASTER", Priority: 3},
+				{Name: "pg2:9999 Linux pg2", Hostname: "pg2", StatusCode: 7, Status: "STANDBY", Priority: 2},
+				{Name: "pg3:9999 Linux pg3", Hostname: "pg3", StatusCode: 8, Status: "LOST", Priority: 1},
+			},
+		},
+		{
+			name: "4.3",
+			data: readFixture(t, "pcp_watchdog_info_4.3.txt"),
+			want: []WatchdogNode{pg1, pg2, pg3},
+		},
+		{
+			// the unfinished last line is dropped
+			name: "truncated",
+			data: truncate(t, readFixture(t, "pcp_watchdog_info_4.3.txt"), "Node priority     : 2\nStatus  "),
+			want: []WatchdogNode{pg1, {Name: "pg2:9999 Linux pg2", Hostname: "pg2", Priority: 2}},
+		},
+		{
+			name: "cluster information only",
+			data: truncate(t, readFixture(t, "pcp_watchdog_info_4.3.txt"), "Watchdog Node Information \n"),
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := WatchdogInfoUnmarshal(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Nodes, tt.want) {
+				t.Errorf("got %+v, want %+v", got.Nodes, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package pgpool2
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Reasons of an ExecError.
+const (
+	ExecFailureNotFound           = "not_found"
+	ExecFailureMissingInterpreter = "missing_interpreter"
+	ExecFailurePermissionDenied   = "permission_denied"
+	ExecFailureWrongArchitecture  = "wrong_architecture"
+	ExecFailureExecFormat         = "exec_format"
+	ExecFailureOther              = "other"
+)
+
+// elfArchitectures names the machines of ELF binaries like GOARCH.
+var elfArchitectures = map[elf.Machine]string{
+	elf.EM_X86_64:  "amd64",
+	elf.EM_386:     "386",
+	elf.EM_AARCH64: "arm64",
+	elf.EM_ARM:     "arm",
+	elf.EM_PPC64:   "ppc64",
+	elf.EM_S390:    "s390x",
+	elf.EM_RISCV:   "riscv64",
+	elf.EM_MIPS:    "mips",
+}
+
+// ExecError is a PCP command that could not be started, with the path of the
+// binary that was checked and the reason, one of the ExecFailure constants.
+type ExecError struct {
+	Path   string
+	Reason string
+	// Detail explains the reason, e.g. the architecture the binary is built
+	// for, empty if the binary tells nothing more
+	Detail string
+	Err    error
+}
+
+func (e *ExecError) Error() string {
+	if len(e.Detail) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v (%s)", e.Err, e.Detail)
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// ExecFailure is a command name and the reason it could not be started.
+type ExecFailure struct {
+	Command string
+	Reason  string
+}
+
+var execFailures = struct {
+	sync.Mutex
+	counts map[ExecFailure]uint64
+}{counts: make(map[ExecFailure]uint64)}
+
+// ExecFailureCounts returns the number of commands that could not be started
+// by command name and reason.
+func ExecFailureCounts() map[ExecFailure]uint64 {
+	execFailures.Lock()
+	defer execFailures.Unlock()
+	counts := make(map[ExecFailure]uint64, len(execFailures.counts))
+	for failure, n := range execFailures.counts {
+		counts[failure] = n
+	}
+	return counts
+}
+
+// newExecError inspects the binary at path that failed to start with err,
+// for a cause more telling than "exec format error", and counts the failure.
+// uid is the user the command was started as.
+func newExecError(path string, err error, uid int) *ExecError {
+	execErr := &ExecError{Path: path, Reason: ExecFailureOther, Err: err}
+	switch {
+	case errors.Is(err, syscall.ENOEXEC):
+		execErr.Reason = ExecFailureExecFormat
+		binary, elfErr := elf.Open(path)
+		if elfErr != nil {
+			execErr.Detail = fmt.Sprintf("%s is not an ELF binary", path)
+			break
+		}
+		defer binary.Close()
+		arch, ok := elfArchitectures[binary.Machine]
+		if !ok {
+			arch = binary.Machine.String()
+		}
+		if arch != runtime.GOARCH {
+			execErr.Reason = ExecFailureWrongArchitecture
+			execErr.Detail = fmt.Sprintf("%s is built for %s, the exporter runs on %s", path, arch, runtime.GOARCH)
+		}
+	case errors.Is(err, syscall.ENOENT):
+		execErr.Reason = ExecFailureNotFound
+		// the kernel reports a missing dynamic loader of an existing binary,
+		// e.g. of one built for musl on glibc, as a missing file as well
+		binary, elfErr := elf.Open(path)
+		if elfErr != nil {
+			break
+		}
+		defer binary.Close()
+		for _, prog := range binary.Progs {
+			if prog.Type != elf.PT_INTERP {
+				continue
+			}
+			interpreter := make([]byte, prog.Filesz)
+			if _, err := prog.ReadAt(interpreter, 0); err != nil {
+				break
+			}
+			name := strings.TrimRight(string(interpreter), "\x00")
+			execErr.Reason = ExecFailureMissingInterpreter
+			execErr.Detail = fmt.Sprintf("the dynamic loader %s of %s is missing", name, path)
+		}
+	case errors.Is(err, syscall.EACCES):
+		execErr.Reason = ExecFailurePermissionDenied
+		info, statErr := os.Stat(path)
+		if statErr != nil {
+			break
+		}
+		if info.IsDir() {
+			execErr.Detail = fmt.Sprintf("%s is a directory", path)
+		} else {
+			execErr.Detail = ownerDetail(path, info, uid)
+		}
+	}
+	execFailures.Lock()
+	execFailures.counts[ExecFailure{Command: filepath.Base(path), Reason: execErr.Reason}]++
+	execFailures.Unlock()
+	return execErr
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package pgpool2
+
+import "os"
+
+// ownerDetail is empty, the files have no unix owner here.
+func ownerDetail(path string, info os.FileInfo, uid int) string {
+	return ""
+}
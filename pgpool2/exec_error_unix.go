@@ -0,0 +1,19 @@
+//go:build unix
+
+package pgpool2
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ownerDetail explains a permission denied by the mode and owner of the
+// binary and the user of the command.
+func ownerDetail(path string, info os.FileInfo, uid int) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%s has mode %s and owner %d:%d, the command runs as uid %d", path, info.Mode(), stat.Uid, stat.Gid, uid)
+}
@@ -0,0 +1,151 @@
+package pgpool2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// processes counts the child processes of the exporter by command name.
+var processes = struct {
+	sync.Mutex
+	started map[string]uint64
+	running int
+}{started: make(map[string]uint64)}
+
+// TrackProcess counts a started child process of the command. The returned
+// function must be called once the process was waited for.
+func TrackProcess(cmd string) func() {
+	name := filepath.Base(cmd)
+	processes.Lock()
+	processes.started[name]++
+	processes.running++
+	processes.Unlock()
+	return func() {
+		processes.Lock()
+		processes.running--
+		processes.Unlock()
+	}
+}
+
+// CloseOnDone closes c once ctx is done, which unblocks a read of the stdout
+// pipe of a killed command whose own children still hold the pipe open. The
+// returned function stops waiting for ctx and must be called once c is no
+// longer read.
+func CloseOnDone(ctx context.Context, c io.Closer) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// ProcessCounts returns the number of child processes started by command
+// name and the number of those that are running.
+func ProcessCounts() (map[string]uint64, int) {
+	processes.Lock()
+	defer processes.Unlock()
+	started := make(map[string]uint64, len(processes.started))
+	for name, n := range processes.started {
+		started[name] = n
+	}
+	return started, processes.running
+}
+
+// Executor runs a PCP command with the given arguments and environment and
+// streams its stdout into parse. Errors of the command take precedence over
+// parse errors, as its output is incomplete then.
+type Executor interface {
+	Exec(ctx context.Context, parse func(io.Reader) error, cmd string, args []string, env []string) error
+}
+
+// Credential is a user and its groups to run the PCP commands as.
+type Credential struct {
+	Uid    uint32
+	Gid    uint32
+	Groups []uint32
+}
+
+// ExecExecutor runs the PCP commands as local processes. It is the default
+// executor of a client.
+type ExecExecutor struct {
+	// Credential runs the commands as another user, which needs root or the
+	// CAP_SETUID and CAP_SETGID capabilities and is only supported on unix;
+	// nil keeps the exporter user
+	Credential *Credential
+}
+
+func (e ExecExecutor) Exec(ctx context.Context, parse func(io.Reader) error, cmd string, args []string, env []string) error {
+	pgpoolExec := exec.CommandContext(ctx, cmd, args...)
+	pgpoolExec.Env = env
+	if e.Credential != nil {
+		if err := setCredential(pgpoolExec, e.Credential); err != nil {
+			return err
+		}
+	}
+	stdout, err := pgpoolExec.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	// read by the exporter instead of exec, whose copy would make Wait block
+	// on children of the command that hold the pipe
+	stderrPipe, err := pgpoolExec.StderrPipe()
+	if err != nil {
+		return err
+	}
+	// the pipes are closed by Start if it fails and by Wait otherwise
+	if err := pgpoolExec.Start(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, syscall.EMFILE) {
+			return fmt.Errorf("%v, raise the open files limit of the exporter (ulimit -n)", err)
+		}
+		uid := os.Getuid()
+		if e.Credential != nil {
+			uid = int(e.Credential.Uid)
+		}
+		return newExecError(cmd, err, uid)
+	}
+	defer TrackProcess(cmd)()
+	stopStdout := CloseOnDone(ctx, stdout)
+	stopStderr := CloseOnDone(ctx, stderrPipe)
+	var stderr stderrBuffer
+	stderrRead := make(chan struct{})
+	go func() {
+		defer close(stderrRead)
+		io.Copy(&stderr, stderrPipe)
+	}()
+	parseErr := parse(stdout)
+	// let the command finish writing if the parser stopped early
+	io.Copy(io.Discard, stdout)
+	stopStdout()
+	<-stderrRead
+	stopStderr()
+	err = pgpoolExec.Wait()
+	if err != nil {
+		// report the deadline instead of "signal: killed"
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else {
+			err = &CommandError{Command: filepath.Base(cmd), Err: err, Stderr: stderr.String()}
+		}
+	} else if parseErr != nil && ctx.Err() != nil {
+		// the pipe was closed under the parser
+		err = ctx.Err()
+	} else {
+		err = parseErr
+	}
+	recordStderr(cmd, stderr.String(), err)
+	return err
+}
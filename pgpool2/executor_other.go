@@ -0,0 +1,12 @@
+//go:build !unix
+
+package pgpool2
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func setCredential(cmd *exec.Cmd, credential *Credential) error {
+	return errors.New("setuid run-as not supported on this platform")
+}
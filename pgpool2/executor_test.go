@@ -0,0 +1,66 @@
+package pgpool2
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// openFiles returns the number of open file descriptors of the test.
+func openFiles(t *testing.T) int {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(fds)
+}
+
+func TestExecExecutorClosesFilesOnFailure(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("no /proc/self/fd to count open files")
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh to run failing commands")
+	}
+	discard := func(r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}
+	tests := []struct {
+		name    string
+		n       int
+		timeout time.Duration
+		cmd     string
+		args    []string
+	}{
+		{name: "missing command", n: 2000, cmd: "/nonexistent/pcp_node_count"},
+		{name: "failing command", n: 2000, cmd: sh, args: []string{"-c", "echo failed >&2; exit 1"}},
+		// the background sleep holds the pipes after the command was killed
+		{name: "deadline", n: 20, timeout: 20 * time.Millisecond, cmd: sh, args: []string{"-c", "sleep 1 & sleep 1"}},
+	}
+	var executor ExecExecutor
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseline := openFiles(t)
+			for i := 0; i < tt.n; i++ {
+				ctx, cancel := context.Background(), context.CancelFunc(func() {})
+				if tt.timeout != 0 {
+					ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				}
+				err := executor.Exec(ctx, discard, tt.cmd, tt.args, nil)
+				cancel()
+				if err == nil {
+					t.Fatalf("call %d: no error", i)
+				}
+			}
+			if n := openFiles(t); n != baseline {
+				t.Errorf("%d open files after %d calls, want %d", n, tt.n, baseline)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package pgpool2
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setCredential makes cmd run as the user of credential.
+func setCredential(cmd *exec.Cmd, credential *Credential) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{
+		Uid:    credential.Uid,
+		Gid:    credential.Gid,
+		Groups: credential.Groups,
+	}}
+	return nil
+}
@@ -0,0 +1,68 @@
+package pgpool2
+
+// ClientOption configures a client created with New. New settings are added as
+// options, so the Options struct does not have to change for them.
+type ClientOption func(*Client)
+
+// WithOptions sets all connection settings at once.
+func WithOptions(options Options) ClientOption {
+	return func(c *Client) {
+		c.options = options
+	}
+}
+
+func WithHost(hostname string) ClientOption {
+	return func(c *Client) {
+		c.options.Hostname = hostname
+	}
+}
+
+func WithPort(port int) ClientOption {
+	return func(c *Client) {
+		c.options.Port = port
+	}
+}
+
+func WithUsername(username string) ClientOption {
+	return func(c *Client) {
+		c.options.Username = username
+	}
+}
+
+func WithPassword(password string) ClientOption {
+	return func(c *Client) {
+		c.options.Password = password
+	}
+}
+
+// WithPassFile uses an existing PCP password file instead of the password.
+func WithPassFile(path string) ClientOption {
+	return func(c *Client) {
+		c.options.PassFile = path
+	}
+}
+
+// WithExecutor replaces how PCP commands are run, e.g. to run them remotely or
+// to return canned output.
+func WithExecutor(executor Executor) ClientOption {
+	return func(c *Client) {
+		c.executor = executor
+	}
+}
+
+// WithVersion sets the pgpool version, which VersionContext returns instead of
+// running pcp_node_count --version, e.g. for a remote pgpool whose version
+// differs from that of the local pcp tools.
+func WithVersion(version Version) ClientOption {
+	return func(c *Client) {
+		c.version = &version
+	}
+}
+
+// WithNodeInfoOverrides replaces how fields of pcp_node_info output are found,
+// e.g. to cope with an output format change before the parser is fixed.
+func WithNodeInfoOverrides(overrides NodeInfoOverrides) ClientOption {
+	return func(c *Client) {
+		c.nodeInfoOverrides = overrides
+	}
+}
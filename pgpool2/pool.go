@@ -0,0 +1,46 @@
+package pgpool2
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBufferSize keeps the rare huge output from pinning memory in the
+// pool.
+const maxPooledBufferSize = 64 << 10
+
+// The readers and buffers of PCP outputs are reused between scrapes, so
+// steady-state scraping does not allocate them every time.
+var (
+	readerPool = sync.Pool{
+		New: func() interface{} { return bufio.NewReader(nil) },
+	}
+	bufferPool = sync.Pool{
+		New: func() interface{} { return &bytes.Buffer{} },
+	}
+)
+
+func getReader(r io.Reader) *bufio.Reader {
+	reader := readerPool.Get().(*bufio.Reader)
+	reader.Reset(r)
+	return reader
+}
+
+func putReader(reader *bufio.Reader) {
+	reader.Reset(nil)
+	readerPool.Put(reader)
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() > maxPooledBufferSize {
+		return
+	}
+	buffer.Reset()
+	bufferPool.Put(buffer)
+}
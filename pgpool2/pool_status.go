@@ -0,0 +1,136 @@
+package pgpool2
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const PCPPoolStatus = "/usr/sbin/pcp_pool_status"
+
+// PoolStatusParam is one configuration parameter reported by
+// pcp_pool_status.
+type PoolStatusParam struct {
+	Name        string `json:"name" yaml:"name"`
+	Value       string `json:"value" yaml:"value"`
+	Description string `json:"description" yaml:"description"`
+}
+
+// ExecPoolStatus is ExecPoolStatusContext with the background context, only
+// Options.Timeout ends a hung command.
+//
+// Deprecated: use ExecPoolStatusContext.
+func (c *Client) ExecPoolStatus() ([]PoolStatusParam, error) {
+	return c.ExecPoolStatusContext(context.Background())
+}
+
+func (c *Client) ExecPoolStatusContext(ctx context.Context) ([]PoolStatusParam, error) {
+	var params []PoolStatusParam
+	err := c.execCommand(ctx, func(r io.Reader) (err error) {
+		params, err = PoolStatusUnmarshal(r)
+		return err
+	}, PCPPoolStatus)
+	if err != nil {
+		return []PoolStatusParam{}, err
+	}
+	return params, nil
+}
+
+// PoolStatusUnmarshal parses the output of pcp_pool_status, where every
+// parameter is a block of "name :", "value:" and "desc :" lines.
+func PoolStatusUnmarshal(cmdOutBuff io.Reader) ([]PoolStatusParam, error) {
+	var params []PoolStatusParam
+	reader := getReader(cmdOutBuff)
+	defer putReader(reader)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				break
+			} else {
+				return params, err
+			}
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "name") {
+			params = append(params, PoolStatusParam{
+				Name: ExtractValueFromPCPString(line),
+			})
+			continue
+		}
+		if len(params) == 0 {
+			continue
+		}
+		param := &params[len(params)-1]
+		if strings.HasPrefix(line, "value") {
+			param.Value = ExtractValueFromPCPString(line)
+		}
+		if strings.HasPrefix(line, "desc") {
+			param.Description = ExtractValueFromPCPString(line)
+		}
+	}
+	return params, nil
+}
+
+// DetectClusterMode returns the clustering mode from the parameters reported
+// by pcp_pool_status: backend_clustering_mode since pgpool 4.2,
+// master_slave_mode, master_slave_sub_mode and replication_mode before. It
+// returns an empty string if the parameters do not tell.
+func DetectClusterMode(params []PoolStatusParam) string {
+	values := make(map[string]string)
+	for _, param := range params {
+		values[param.Name] = strings.ToLower(strings.TrimSpace(param.Value))
+	}
+	if mode, ok := values["backend_clustering_mode"]; ok {
+		for _, m := range ClusterModes {
+			if m == mode {
+				return mode
+			}
+		}
+		return ""
+	}
+	// older versions report booleans as 1/0, newer ones as on/off
+	isOn := func(name string) bool {
+		switch values[name] {
+		case "1", "on", "true", "yes":
+			return true
+		}
+		return false
+	}
+	if isOn("replication_mode") {
+		return ClusterModeNativeReplication
+	}
+	if isOn("master_slave_mode") {
+		switch values["master_slave_sub_mode"] {
+		case "slony":
+			return ClusterModeSlony
+		case "logical":
+			return ClusterModeLogicalReplication
+		}
+		return ClusterModeStreamingReplication
+	}
+	_, hasReplicationMode := values["replication_mode"]
+	_, hasMasterSlaveMode := values["master_slave_mode"]
+	if hasReplicationMode || hasMasterSlaveMode {
+		return ClusterModeRaw
+	}
+	return ""
+}
+
+// BackendStatuses returns the backend_status<id> parameters reported by
+// pcp_pool_status, e.g. up or down, by node id.
+func BackendStatuses(params []PoolStatusParam) map[int]string {
+	statuses := make(map[int]string)
+	for _, param := range params {
+		if !strings.HasPrefix(param.Name, "backend_status") {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(param.Name, "backend_status"))
+		if err != nil {
+			continue
+		}
+		statuses[id] = strings.TrimSpace(param.Value)
+	}
+	return statuses
+}
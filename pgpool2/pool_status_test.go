@@ -0,0 +1,138 @@
+package pgpool2
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// poolStatusFixture parses a pcp_pool_status output in testdata.
+func poolStatusFixture(t *testing.T, name string) []PoolStatusParam {
+	t.Helper()
+	params, err := PoolStatusUnmarshal(bytes.NewReader(readFixture(t, name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return params
+}
+
+func TestPoolStatusUnmarshal(t *testing.T) {
+	listenAddresses := PoolStatusParam{Name: "listen_addresses", Value: "*", Description: "host name(s) or IP address(es) to listen on"}
+	tests := []struct {
+		name string
+		data []byte
+		want []PoolStatusParam
+	}{
+		{
+			name: "4.1",
+			data: readFixture(t, "pcp_pool_status_4.1.txt"),
+			want: []PoolStatusParam{
+				listenAddresses,
+				{Name: "replication_mode", Value: "off", Description: "non 0 if operating in replication mode"},
+				{Name: "master_slave_mode", Value: "on", Description: "if true, operate in master/slave mode"},
+				{Name: "master_slave_sub_mode", Value: "stream", Description: "master/slave sub mode"},
+				{Name: "backend_status0", Value: "up", Description: "status of backend #0"},
+				{Name: "backend_status1", Value: "waiting", Description: "status of backend #1"},
+			},
+		},
+		{
+			// the unfinished last line is dropped
+			name: "truncated",
+			data: truncate(t, readFixture(t, "pcp_pool_status_4.2.txt"), "name : port\nvalue: 99"),
+			want: []PoolStatusParam{listenAddresses, {Name: "port"}},
+		},
+		{
+			name: "description with colon",
+			data: []byte("name : ssl_ciphers\nvalue: HIGH:MEDIUM:+3DES:!aNULL\ndesc : allowed SSL ciphers: HIGH by default\n"),
+			want: []PoolStatusParam{{Name: "ssl_ciphers", Value: "HIGH:MEDIUM:+3DES:!aNULL", Description: "allowed SSL ciphers: HIGH by default"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PoolStatusUnmarshal(bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectClusterMode(t *testing.T) {
+	param := func(name, value string) PoolStatusParam {
+		return PoolStatusParam{Name: name, Value: value}
+	}
+	tests := []struct {
+		name   string
+		params []PoolStatusParam
+		want   string
+	}{
+		{name: "4.2", params: poolStatusFixture(t, "pcp_pool_status_4.2.txt"), want: ClusterModeStreamingReplication},
+		{name: "4.1", params: poolStatusFixture(t, "pcp_pool_status_4.1.txt"), want: ClusterModeStreamingReplication},
+		{name: "3.6", params: poolStatusFixture(t, "pcp_pool_status_3.6.txt"), want: ClusterModeNativeReplication},
+		{
+			name:   "backend_clustering_mode in upper case",
+			params: []PoolStatusParam{param("backend_clustering_mode", " Snapshot_Isolation ")},
+			want:   ClusterModeSnapshotIsolation,
+		},
+		{
+			name:   "unknown backend_clustering_mode",
+			params: []PoolStatusParam{param("backend_clustering_mode", "sharding"), param("replication_mode", "on")},
+			want:   "",
+		},
+		{
+			name:   "slony",
+			params: []PoolStatusParam{param("replication_mode", "0"), param("master_slave_mode", "1"), param("master_slave_sub_mode", "slony")},
+			want:   ClusterModeSlony,
+		},
+		{
+			name:   "logical",
+			params: []PoolStatusParam{param("master_slave_mode", "on"), param("master_slave_sub_mode", "logical")},
+			want:   ClusterModeLogicalReplication,
+		},
+		{
+			name:   "raw",
+			params: []PoolStatusParam{param("replication_mode", "off"), param("master_slave_mode", "off")},
+			want:   ClusterModeRaw,
+		},
+		{name: "no mode parameters", params: []PoolStatusParam{param("port", "9999")}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectClusterMode(tt.params); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackendStatuses(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []PoolStatusParam
+		want   map[int]string
+	}{
+		{name: "4.2", params: poolStatusFixture(t, "pcp_pool_status_4.2.txt"), want: map[int]string{0: "up", 1: "down"}},
+		{name: "4.1", params: poolStatusFixture(t, "pcp_pool_status_4.1.txt"), want: map[int]string{0: "up", 1: "waiting"}},
+		// older versions report the status code
+		{name: "3.6", params: poolStatusFixture(t, "pcp_pool_status_3.6.txt"), want: map[int]string{0: "2"}},
+		{
+			name: "names without node id",
+			params: []PoolStatusParam{
+				{Name: "backend_status", Value: "up"},
+				{Name: "backend_status_name1", Value: "up"},
+				{Name: "backend_status12", Value: " down "},
+			},
+			want: map[int]string{12: "down"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BackendStatuses(tt.params); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
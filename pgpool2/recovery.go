@@ -0,0 +1,27 @@
+package pgpool2
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+const PCPRecoveryNode = "/usr/sbin/pcp_recovery_node"
+
+// ExecRecoveryNode is ExecRecoveryNodeContext with the background context, only
+// Options.Timeout ends a hung command.
+//
+// Deprecated: use ExecRecoveryNodeContext.
+func (c *Client) ExecRecoveryNode(nodeID int) error {
+	return c.ExecRecoveryNodeContext(context.Background(), nodeID)
+}
+
+// ExecRecoveryNodeContext attaches the node back with online recovery. It
+// returns when pgpool finished the recovery, which can take as long as a base
+// backup of the primary.
+func (c *Client) ExecRecoveryNodeContext(ctx context.Context, nodeID int) error {
+	return c.execCommand(ctx, func(r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}, PCPRecoveryNode, fmt.Sprintf("--node-id=%d", nodeID))
+}
@@ -0,0 +1,244 @@
+package pgpool2
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// nodeStatusCodes maps the status of SHOW POOL_NODES to the status code of
+// pcp_node_info.
+var nodeStatusCodes = map[string]int{
+	"unused":  0,
+	"waiting": 1,
+	"up":      2,
+	"down":    3,
+}
+
+// SQLExecutor answers the PCP commands with the SHOW commands of pgpool on its
+// SQL listener, for sites that block the PCP port. The rows are written in the
+// verbose output format of the pcp tools, so that the same parsers read them.
+// Every command opens its own connection, like the pcp tools, so that no
+// child process of pgpool is held between scrapes. Commands without SQL
+// equivalent, like pcp_watchdog_info, fail.
+type SQLExecutor struct {
+	// Connector connects to the SQL listener of pgpool
+	Connector driver.Connector
+}
+
+func (e SQLExecutor) Exec(ctx context.Context, parse func(io.Reader) error, cmd string, args []string, env []string) error {
+	output := getBuffer()
+	defer putBuffer(output)
+	db := sql.OpenDB(e.Connector)
+	defer db.Close()
+	var err error
+	switch cmd {
+	case PCPNodeCount:
+		if containsArg(args, "--version") {
+			err = writeSQLVersion(ctx, db, output, cmd)
+		} else {
+			err = writeSQLNodeCount(ctx, db, output)
+		}
+	case PCPNodeInfo:
+		err = writeSQLNodeInfo(ctx, db, output, args)
+	case PCPProcCount:
+		err = writeSQLProcCount(ctx, db, output)
+	case PCPProcInfo:
+		err = writeSQLProcInfo(ctx, db, output)
+	case PCPPoolStatus:
+		err = writeSQLPoolStatus(ctx, db, output)
+	case SQLBackendStats:
+		err = writeSQLBackendStats(ctx, db, output)
+	default:
+		err = fmt.Errorf("%s has no SQL equivalent", filepath.Base(cmd))
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return parse(output)
+}
+
+func containsArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// showRows runs a SHOW command and returns its rows by column name. Columns
+// that older pgpool versions do not report are missing, and read as empty.
+func showRows(ctx context.Context, db *sql.DB, query string) ([]map[string]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", query, err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", query, err)
+	}
+	var result []map[string]string
+	values := make([]sql.NullString, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("%s: %v", query, err)
+		}
+		row := make(map[string]string, len(columns))
+		for i, column := range columns {
+			row[column] = values[i].String
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", query, err)
+	}
+	return result, nil
+}
+
+// writeSQLVersion writes the version of pgpool like --version of the pcp
+// tools. SHOW POOL_VERSION reports it with the release name, e.g.
+// "4.3.5 (tamahomeboshi)".
+func writeSQLVersion(ctx context.Context, db *sql.DB, w *bytes.Buffer, cmd string) error {
+	rows, err := showRows(ctx, db, "SHOW POOL_VERSION")
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("SHOW POOL_VERSION returned no row")
+	}
+	fmt.Fprintf(w, "%s (pgpool-II) %s\n", filepath.Base(cmd), rows[0]["pool_version"])
+	return nil
+}
+
+func writeSQLNodeCount(ctx context.Context, db *sql.DB, w *bytes.Buffer) error {
+	rows, err := showRows(ctx, db, "SHOW POOL_NODES")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%d\n", len(rows))
+	return nil
+}
+
+func writeSQLNodeInfo(ctx context.Context, db *sql.DB, w *bytes.Buffer, args []string) error {
+	var nodeID string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--node-id=") {
+			nodeID = strings.TrimPrefix(arg, "--node-id=")
+		}
+	}
+	rows, err := showRows(ctx, db, "SHOW POOL_NODES")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if row["node_id"] != nodeID {
+			continue
+		}
+		status := row["status"]
+		if code, ok := nodeStatusCodes[status]; ok {
+			status = strconv.Itoa(code)
+		}
+		// the delay is in bytes, or in seconds with a unit since 4.3 if
+		// delay_threshold_by_time is set, which NodeInfoUnmarshal reads
+		delay := row["replication_delay"]
+		fmt.Fprintf(w, "Hostname               : %s\n", row["hostname"])
+		fmt.Fprintf(w, "Port                   : %s\n", row["port"])
+		fmt.Fprintf(w, "Status                 : %s\n", status)
+		fmt.Fprintf(w, "Weight                 : %s\n", row["lb_weight"])
+		fmt.Fprintf(w, "Status Name            : %s\n", row["status"])
+		fmt.Fprintf(w, "Role                   : %s\n", row["role"])
+		fmt.Fprintf(w, "Replication Delay      : %s\n", delay)
+		fmt.Fprintf(w, "Replication State      : %s\n", row["replication_state"])
+		fmt.Fprintf(w, "Replication Sync State : %s\n", row["replication_sync_state"])
+		fmt.Fprintf(w, "Last Status Change     : %s\n", row["last_status_change"])
+		return nil
+	}
+	return fmt.Errorf("invalid node id %s", nodeID)
+}
+
+// writeSQLProcCount writes the pids of the child processes separated by
+// spaces, like pcp_proc_count.
+func writeSQLProcCount(ctx context.Context, db *sql.DB, w *bytes.Buffer) error {
+	rows, err := showRows(ctx, db, "SHOW POOL_PROCESSES")
+	if err != nil {
+		return err
+	}
+	var pids []string
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		if pid := row["pool_pid"]; !seen[pid] {
+			seen[pid] = true
+			pids = append(pids, pid)
+		}
+	}
+	fmt.Fprintln(w, strings.Join(pids, " "))
+	return nil
+}
+
+// writeSQLProcInfo writes every connection slot of SHOW POOL_POOLS like
+// pcp_proc_info --all does.
+func writeSQLProcInfo(ctx context.Context, db *sql.DB, w *bytes.Buffer) error {
+	rows, err := showRows(ctx, db, "SHOW POOL_POOLS")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		fmt.Fprintf(w, "Database               : %s\n", row["database"])
+		fmt.Fprintf(w, "Username               : %s\n", row["username"])
+		fmt.Fprintf(w, "PID                    : %s\n", row["pool_pid"])
+		fmt.Fprintf(w, "Connected              : %s\n", row["pool_connected"])
+		fmt.Fprintf(w, "Client connection time : %s\n", row["client_connection_time"])
+		fmt.Fprintf(w, "Client idle duration   : %s\n", row["client_idle_duration"])
+		fmt.Fprintf(w, "Status                 : %s\n\n", row["status"])
+	}
+	return nil
+}
+
+func writeSQLPoolStatus(ctx context.Context, db *sql.DB, w *bytes.Buffer) error {
+	rows, err := showRows(ctx, db, "SHOW POOL_STATUS")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		fmt.Fprintf(w, "name : %s\nvalue: %s\ndesc : %s\n\n", row["item"], row["value"], row["description"])
+	}
+	return nil
+}
+
+// writeSQLBackendStats writes the statement counters of every backend node of
+// SHOW POOL_BACKEND_STATS in the format BackendStatsUnmarshal reads.
+func writeSQLBackendStats(ctx context.Context, db *sql.DB, w *bytes.Buffer) error {
+	rows, err := showRows(ctx, db, "SHOW POOL_BACKEND_STATS")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		fmt.Fprintf(w, "Node ID      : %s\n", row["node_id"])
+		fmt.Fprintf(w, "Hostname     : %s\n", row["hostname"])
+		fmt.Fprintf(w, "Select Count : %s\n", row["select_cnt"])
+		fmt.Fprintf(w, "Insert Count : %s\n", row["insert_cnt"])
+		fmt.Fprintf(w, "Update Count : %s\n", row["update_cnt"])
+		fmt.Fprintf(w, "Delete Count : %s\n", row["delete_cnt"])
+		fmt.Fprintf(w, "DDL Count    : %s\n", row["ddl_cnt"])
+		fmt.Fprintf(w, "Other Count  : %s\n", row["other_cnt"])
+		fmt.Fprintf(w, "Panic Count  : %s\n", row["panic_cnt"])
+		fmt.Fprintf(w, "Fatal Count  : %s\n", row["fatal_cnt"])
+		fmt.Fprintf(w, "Error Count  : %s\n\n", row["error_cnt"])
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package pgpool2
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// maxStderrBytes caps the stderr kept of a command, the pcp tools print a few
+// lines at most.
+const maxStderrBytes = 4096
+
+// CommandError is a failed PCP command with what it printed on stderr, where
+// the pcp tools explain why they failed.
+type CommandError struct {
+	Command string
+	Err     error
+	Stderr  string
+}
+
+func (e *CommandError) Error() string {
+	if len(e.Stderr) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v: %s", e.Err, strings.Join(strings.Fields(e.Stderr), " "))
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// stderrBuffer keeps the first maxStderrBytes written to it and discards the
+// rest, so that a chatty command neither blocks nor grows the exporter.
+type stderrBuffer struct {
+	buf       []byte
+	truncated bool
+}
+
+func (b *stderrBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := maxStderrBytes - len(b.buf); room < n {
+		b.truncated = true
+		p = p[:room]
+	}
+	b.buf = append(b.buf, p...)
+	return n, nil
+}
+
+// String returns the kept stderr without a rune cut in half by the cap.
+func (b *stderrBuffer) String() string {
+	buf := b.buf
+	if !b.truncated {
+		return strings.TrimSpace(string(buf))
+	}
+	for i := 1; i < utf8.UTFMax && len(buf) > 0; i++ {
+		if r, size := utf8.DecodeLastRune(buf); r != utf8.RuneError || size != 1 {
+			break
+		}
+		buf = buf[:len(buf)-1]
+	}
+	return strings.TrimSpace(string(buf)) + " [truncated]"
+}
+
+// StderrRecord is the last output of a command on stderr.
+type StderrRecord struct {
+	Time   time.Time `json:"time"`
+	Stderr string    `json:"stderr"`
+	// Error is the error of the command, empty if it succeeded anyway
+	Error string `json:"error,omitempty"`
+	// Count is the number of runs that printed on stderr
+	Count uint64 `json:"count"`
+}
+
+var lastStderr = struct {
+	sync.Mutex
+	records map[string]StderrRecord
+}{records: make(map[string]StderrRecord)}
+
+// recordStderr keeps the stderr of a run of the command, if it printed any.
+func recordStderr(cmd, stderr string, err error) {
+	if len(stderr) == 0 {
+		return
+	}
+	name := filepath.Base(cmd)
+	lastStderr.Lock()
+	defer lastStderr.Unlock()
+	record := StderrRecord{Time: time.Now(), Stderr: stderr, Count: lastStderr.records[name].Count + 1}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	lastStderr.records[name] = record
+}
+
+// LastStderr returns the last stderr output by command name.
+func LastStderr() map[string]StderrRecord {
+	lastStderr.Lock()
+	defer lastStderr.Unlock()
+	records := make(map[string]StderrRecord, len(lastStderr.records))
+	for name, record := range lastStderr.records {
+		records[name] = record
+	}
+	return records
+}
@@ -0,0 +1,122 @@
+package pgpool2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	versionRegexp       = regexp.MustCompile(`\(pgpool-II\)\s+(\d+)\.(\d+)(?:\.(\d+))?`)
+	versionNumberRegexp = regexp.MustCompile(`^(\d+)\.(\d+)(?:\.(\d+))?$`)
+)
+
+// Version is a pgpool version.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast reports whether v is the version since or newer.
+func (v Version) AtLeast(since Version) bool {
+	if v.Major != since.Major {
+		return v.Major > since.Major
+	}
+	if v.Minor != since.Minor {
+		return v.Minor > since.Minor
+	}
+	return v.Patch >= since.Patch
+}
+
+// Capability is a feature of pgpool the exporter uses, which older versions
+// do not have.
+type Capability struct {
+	Name  string
+	Since Version
+}
+
+// Capabilities are the features of pgpool that depend on its version, with
+// the first version that has them.
+var Capabilities = []Capability{
+	// PCPPASSFILE instead of the password as argument of the pcp tools
+	{Name: "pcppass_file", Since: Version{3, 5, 0}},
+	// pcp_health_check_stats
+	{Name: "health_check_stats", Since: Version{4, 1, 0}},
+	// pcp_node_info --all
+	{Name: "node_info_all", Since: Version{4, 2, 0}},
+	// backend_clustering_mode in pcp_pool_status
+	{Name: "clustering_mode", Since: Version{4, 2, 0}},
+	// client idle duration and status in pcp_proc_info
+	{Name: "proc_info_client_status", Since: Version{4, 2, 0}},
+	// member remote nodes and quorum size in pcp_watchdog_info
+	{Name: "watchdog_membership", Since: Version{4, 3, 0}},
+}
+
+// ParseVersion parses the version printed by the pcp tools with --version,
+// e.g. "pcp_node_count (pgpool-II) 4.3.5".
+func ParseVersion(output string) (Version, error) {
+	match := versionRegexp.FindStringSubmatch(output)
+	if match == nil {
+		return Version{}, fmt.Errorf("no pgpool version in %q", output)
+	}
+	return versionOfMatch(match), nil
+}
+
+// ParseVersionNumber parses a version number like "4.3.5" or "4.3".
+func ParseVersionNumber(number string) (Version, error) {
+	match := versionNumberRegexp.FindStringSubmatch(strings.TrimSpace(number))
+	if match == nil {
+		return Version{}, fmt.Errorf("invalid pgpool version %q", number)
+	}
+	return versionOfMatch(match), nil
+}
+
+func versionOfMatch(match []string) Version {
+	var v Version
+	v.Major, _ = strconv.Atoi(match[1])
+	v.Minor, _ = strconv.Atoi(match[2])
+	if len(match[3]) != 0 {
+		v.Patch, _ = strconv.Atoi(match[3])
+	}
+	return v
+}
+
+// Version is VersionContext with the background context, only Options.Timeout
+// ends a hung command.
+//
+// Deprecated: use VersionContext.
+func (c *Client) Version() (Version, error) {
+	return c.VersionContext(context.Background())
+}
+
+// VersionContext returns the version of the pcp tools, which is the version
+// of pgpool where they are installed with it. It is not the version of a
+// remote pgpool, unless the executor answers --version for pgpool like
+// SQLExecutor does, or the version set with WithVersion, which runs no
+// command.
+func (c *Client) VersionContext(ctx context.Context) (Version, error) {
+	if c.version != nil {
+		return *c.version, nil
+	}
+	var version Version
+	err := c.execCommand(ctx, func(r io.Reader) error {
+		output, err := io.ReadAll(io.LimitReader(r, 4096))
+		if err != nil {
+			return err
+		}
+		version, err = ParseVersion(string(output))
+		return err
+	}, PCPNodeCount, "--version")
+	if err != nil {
+		return Version{}, err
+	}
+	return version, nil
+}
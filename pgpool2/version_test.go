@@ -0,0 +1,63 @@
+package pgpool2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestParseVersionNumber(t *testing.T) {
+	tests := []struct {
+		number  string
+		want    Version
+		invalid bool
+	}{
+		{number: "4.3.5", want: Version{4, 3, 5}},
+		{number: "4.2", want: Version{4, 2, 0}},
+		{number: " 3.6.22 ", want: Version{3, 6, 22}},
+		{number: "4", invalid: true},
+		{number: "4.3.5-rc1", invalid: true},
+		{number: "pcp_node_count (pgpool-II) 4.3.5", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			got, err := ParseVersionNumber(tt.number)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("got %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// failingExecutor fails every command.
+type failingExecutor struct{}
+
+func (failingExecutor) Exec(ctx context.Context, parse func(io.Reader) error, cmd string, args []string, env []string) error {
+	return errors.New("no command expected")
+}
+
+func TestVersionContextWithVersion(t *testing.T) {
+	client, err := New(WithHost("localhost"), WithPort(9898), WithUsername("pgpool"), WithPassword("secret"),
+		WithExecutor(failingExecutor{}), WithVersion(Version{4, 1, 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Clean()
+	version, err := client.VersionContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != (Version{4, 1, 2}) {
+		t.Errorf("got %s, want 4.1.2", version)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// AuthModule holds PCP credentials that /probe requests reference by name, so
+// scrape configs never contain them. Empty fields fall back to the -pcp.* flags.
+type AuthModule struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	PassFile string `yaml:"passfile"`
+	// Hosts are the pgpools that may be probed with the module, as host,
+	// which allows every port, or host:port
+	Hosts []string `yaml:"hosts"`
+}
+
+// Validate checks that the module allows some hosts, so that a probe cannot
+// send its credentials to any address.
+func (m AuthModule) Validate() error {
+	if len(m.Hosts) == 0 {
+		return fmt.Errorf("no hosts")
+	}
+	for _, entry := range m.Hosts {
+		if len(entry) == 0 {
+			return fmt.Errorf("empty host")
+		}
+		if _, port, err := net.SplitHostPort(entry); err == nil {
+			if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+				return fmt.Errorf("invalid port in host %q", entry)
+			}
+		}
+	}
+	return nil
+}
+
+// allows reports whether the host and port of a probe are in Hosts.
+func (m AuthModule) allows(host string, port int) bool {
+	for _, entry := range m.Hosts {
+		entryHost, entryPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			entryHost, entryPort = entry, ""
+		}
+		if !strings.EqualFold(entryHost, host) {
+			continue
+		}
+		if len(entryPort) == 0 || entryPort == strconv.Itoa(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// Prober serves /probe. The target parameter names a configured target, or
+// with the module parameter the host[:port] of any pgpool to collect from with
+// the credentials of that auth module.
+type Prober struct {
+	targets         []*Target
+	modules         map[string]AuthModule
+	defaults        pgpool2.Options
+	exporterOptions ExporterOptions
+	mappings        []MetricMapping
+	hashing         *LabelHashing
+
+	mutex sync.Mutex
+	// targets created for module probes, reused so that every scrape does not
+	// write a new pcppass file. Beyond maxModuleTargets the least recently
+	// probed one is removed.
+	moduleTargets    map[string]*list.Element
+	moduleLRU        *list.List
+	maxModuleTargets int
+}
+
+// moduleTarget is an element of Prober.moduleLRU.
+type moduleTarget struct {
+	key    string
+	target *Target
+}
+
+func NewProber(targets []*Target, config *Config, defaults pgpool2.Options, exporterOptions ExporterOptions, maxModuleTargets int) *Prober {
+	return &Prober{
+		targets:          targets,
+		modules:          config.AuthModules,
+		defaults:         defaults,
+		exporterOptions:  exporterOptions,
+		mappings:         config.MetricMappings,
+		hashing:          config.LabelHashing,
+		moduleTargets:    make(map[string]*list.Element),
+		moduleLRU:        list.New(),
+		maxModuleTargets: maxModuleTargets,
+	}
+}
+
+func (p *Prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("target")
+	if len(name) == 0 {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	var target *Target
+	if module := r.URL.Query().Get("module"); len(module) != 0 {
+		var err error
+		target, err = p.moduleTarget(module, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		target = findTarget(p.targets, name)
+		if target == nil {
+			http.Error(w, fmt.Sprintf("unknown target %q", name), http.StatusNotFound)
+			return
+		}
+	}
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+	ctx, err := requestContext(ctx, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	registry, err := target.registry(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	promhttp.HandlerFor(newMappingGatherer(newHashingGatherer(registry, p.hashing), p.mappings), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+func (p *Prober) moduleTarget(moduleName string, address string) (*Target, error) {
+	module, ok := p.modules[moduleName]
+	if !ok {
+		return nil, fmt.Errorf("unknown auth module %q", moduleName)
+	}
+	targetConfig := TargetConfig{
+		Name:     address,
+		Host:     address,
+		Username: module.Username,
+		Password: module.Password,
+		PassFile: module.PassFile,
+	}
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		portInt, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in target %q", address)
+		}
+		targetConfig.Host = host
+		targetConfig.Port = portInt
+	}
+	options := targetConfig.Options(p.defaults)
+	if !module.allows(options.Hostname, options.Port) {
+		return nil, fmt.Errorf("target %q is not in the hosts of auth module %q", address, moduleName)
+	}
+	key := moduleName + "/" + address
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if element, ok := p.moduleTargets[key]; ok {
+		p.moduleLRU.MoveToFront(element)
+		return element.Value.(*moduleTarget).target, nil
+	}
+	target, err := NewTarget(address, []pgpool2.Options{options}, p.exporterOptions)
+	if err != nil {
+		return nil, err
+	}
+	p.moduleTargets[key] = p.moduleLRU.PushFront(&moduleTarget{key: key, target: target})
+	if p.maxModuleTargets > 0 && p.moduleLRU.Len() > p.maxModuleTargets {
+		oldest := p.moduleLRU.Remove(p.moduleLRU.Back()).(*moduleTarget)
+		delete(p.moduleTargets, oldest.key)
+		oldest.target.clean()
+	}
+	return target, nil
+}
+
+// Targets returns the targets created for module probes so far.
+func (p *Prober) Targets() []*Target {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	targets := make([]*Target, 0, p.moduleLRU.Len())
+	for element := p.moduleLRU.Front(); element != nil; element = element.Next() {
+		targets = append(targets, element.Value.(*moduleTarget).target)
+	}
+	return targets
+}
+
+// Clean removes the temporary files of the targets created for module probes.
+func (p *Prober) Clean() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for element := p.moduleLRU.Front(); element != nil; element = element.Next() {
+		element.Value.(*moduleTarget).target.clean()
+	}
+}
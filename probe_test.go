@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+)
+
+var probeDefaults = pgpool2.Options{Hostname: "127.0.0.1", Port: 9898, Username: "pcpadmin"}
+
+func TestAuthModuleAllows(t *testing.T) {
+	module := AuthModule{Hosts: []string{"pgpool-a", "pgpool-b:9999", "[2001:db8::1]:9898", "PGPOOL-C.example.com"}}
+	tests := []struct {
+		host string
+		port int
+		want bool
+	}{
+		{host: "pgpool-a", port: 9898, want: true},
+		{host: "pgpool-a", port: 10000, want: true},
+		{host: "pgpool-b", port: 9999, want: true},
+		{host: "pgpool-b", port: 9898},
+		{host: "2001:db8::1", port: 9898, want: true},
+		{host: "2001:db8::1", port: 9999},
+		{host: "pgpool-c.example.com", port: 9898, want: true},
+		{host: "pgpool-d", port: 9898},
+		{host: "pgpool-a.evil.example.com", port: 9898},
+	}
+	for _, tt := range tests {
+		if got := module.allows(tt.host, tt.port); got != tt.want {
+			t.Errorf("allows(%q, %d) = %v, want %v", tt.host, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestAuthModuleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hosts   []string
+		invalid bool
+	}{
+		{name: "hosts", hosts: []string{"pgpool-a", "pgpool-b:9898", "[::1]:9898"}},
+		{name: "no hosts", invalid: true},
+		{name: "empty host", hosts: []string{""}, invalid: true},
+		{name: "bad port", hosts: []string{"pgpool-a:pcp"}, invalid: true},
+		{name: "port out of range", hosts: []string{"pgpool-a:70000"}, invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := AuthModule{Hosts: tt.hosts}.Validate()
+			if tt.invalid && err == nil {
+				t.Error("got no error")
+			}
+			if !tt.invalid && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// newModuleProber returns a prober with the auth module prod, which allows
+// pgpool-a to pgpool-c on any port, that keeps maxModuleTargets targets.
+func newModuleProber(t *testing.T, maxModuleTargets int) *Prober {
+	t.Helper()
+	config := &Config{AuthModules: map[string]AuthModule{
+		"prod": {Username: "monitor", Password: "secret", Hosts: []string{"pgpool-a", "pgpool-b", "pgpool-c"}},
+	}}
+	prober := NewProber(nil, config, probeDefaults, ExporterOptions{}, maxModuleTargets)
+	t.Cleanup(prober.Clean)
+	return prober
+}
+
+func TestProberModuleTarget(t *testing.T) {
+	prober := newModuleProber(t, 0)
+	tests := []struct {
+		module   string
+		address  string
+		wantHost string
+		wantPort int
+		invalid  bool
+	}{
+		{module: "prod", address: "pgpool-a", wantHost: "pgpool-a", wantPort: 9898},
+		{module: "prod", address: "pgpool-b:9999", wantHost: "pgpool-b", wantPort: 9999},
+		{module: "prod", address: "pgpool-d:9898", invalid: true},
+		{module: "prod", address: "pgpool-a:pcp", invalid: true},
+		{module: "test", address: "pgpool-a", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.module+"/"+tt.address, func(t *testing.T) {
+			target, err := prober.moduleTarget(tt.module, tt.address)
+			if tt.invalid {
+				if err == nil {
+					t.Error("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			options := target.activeEndpoint().client.Options()
+			if options.Hostname != tt.wantHost || options.Port != tt.wantPort || options.Username != "monitor" {
+				t.Errorf("got %s@%s:%d, want monitor@%s:%d", options.Username, options.Hostname, options.Port, tt.wantHost, tt.wantPort)
+			}
+			again, err := prober.moduleTarget(tt.module, tt.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again != target {
+				t.Error("the target was not reused")
+			}
+		})
+	}
+}
+
+func TestProberModuleTargetEviction(t *testing.T) {
+	prober := newModuleProber(t, 2)
+	a, err := prober.moduleTarget("prod", "pgpool-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := prober.moduleTarget("prod", "pgpool-b"); err != nil {
+		t.Fatal(err)
+	}
+	// pgpool-b is now the least recently probed
+	if _, err := prober.moduleTarget("prod", "pgpool-a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := prober.moduleTarget("prod", "pgpool-c"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, target := range prober.Targets() {
+		names = append(names, target.Name)
+	}
+	if len(names) != 2 || names[0] != "pgpool-c" || names[1] != "pgpool-a" {
+		t.Errorf("got targets %v, want [pgpool-c pgpool-a]", names)
+	}
+	if again, _ := prober.moduleTarget("prod", "pgpool-a"); again != a {
+		t.Error("the target of pgpool-a was not kept")
+	}
+}
+
+func TestProberServeHTTP(t *testing.T) {
+	prober := newModuleProber(t, 0)
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{query: "", want: http.StatusBadRequest},
+		{query: "target=missing", want: http.StatusNotFound},
+		{query: "target=pgpool-d&module=prod", want: http.StatusBadRequest},
+		{query: "target=pgpool-a&module=test", want: http.StatusBadRequest},
+		{query: "target=pgpool-a&module=prod&node_detail=all", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			prober.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/probe?"+tt.query, nil))
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
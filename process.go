@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	procPath   = "/proc"
+	cgroupPath = "/sys/fs/cgroup"
+	pgpoolComm = "pgpool"
+	// clockTicks is USER_HZ, the unit of the start time in /proc/<pid>/stat,
+	// which is 100 on all supported platforms
+	clockTicks = 100
+)
+
+var (
+	ProcessUp = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "process", "up"),
+		"Whether the pgpool parent process is running, independent of PCP",
+		nil, nil,
+	)
+	ProcessChildren = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "process", "children"),
+		"Number of child processes of the pgpool parent process as seen by the OS",
+		nil, nil,
+	)
+	ProcessStartTime = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "process", "start_time_seconds"),
+		"Start time of the pgpool parent process since unix epoch in seconds",
+		nil, nil,
+	)
+	ProcessRestarts = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "process", "restarts_total"),
+		"Number of times the pgpool parent process was seen with another pid or start time than in the scrape before",
+		nil, nil,
+	)
+	ProcessCgroupMemory = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "process", "cgroup_memory_bytes"),
+		"Memory used by the cgroup of the pgpool parent process (memory.current)",
+		nil, nil,
+	)
+	ProcessCgroupMemoryLimit = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "process", "cgroup_memory_limit_bytes"),
+		"Memory limit of the cgroup of the pgpool parent process (memory.max), not exported if there is none",
+		nil, nil,
+	)
+	ProcessCgroupOOMKills = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "process", "cgroup_oom_kills_total"),
+		"Number of processes of the cgroup of the pgpool parent process killed by the OOM killer",
+		nil, nil,
+	)
+	ProcessCgroupCPU = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "process", "cgroup_cpu_seconds_total"),
+		"CPU time used by the cgroup of the pgpool parent process",
+		nil, nil,
+	)
+	ProcessCgroupCPUThrottled = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "process", "cgroup_cpu_throttled_seconds_total"),
+		"Time the cgroup of the pgpool parent process was throttled by its CPU limit",
+		nil, nil,
+	)
+)
+
+// ProcessCollector exports what the OS knows about a pgpool running on the
+// same host, found by its pid file or by a scan of /proc.
+type ProcessCollector struct {
+	pidFile  string
+	liveness bool
+	cgroup   bool
+
+	mutex sync.Mutex
+	// pid and start time of the parent process in the last scrape, to
+	// detect restarts
+	lastPID       int
+	lastStartTime uint64
+	restarts      int
+}
+
+func NewProcessCollector(pidFile string, liveness bool, cgroup bool) *ProcessCollector {
+	return &ProcessCollector{
+		pidFile:  pidFile,
+		liveness: liveness,
+		cgroup:   cgroup,
+	}
+}
+
+func (c *ProcessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ProcessUp
+	ch <- ProcessChildren
+	ch <- ProcessStartTime
+	ch <- ProcessRestarts
+	ch <- ProcessCgroupMemory
+	ch <- ProcessCgroupMemoryLimit
+	ch <- ProcessCgroupOOMKills
+	ch <- ProcessCgroupCPU
+	ch <- ProcessCgroupCPUThrottled
+}
+
+func (c *ProcessCollector) Collect(ch chan<- prometheus.Metric) {
+	processes, err := scanProcesses()
+	if err != nil {
+		logrus.Warnf("Cannot list processes: %v", err)
+		return
+	}
+	pid, err := c.findPID(processes)
+	if err != nil {
+		logrus.Warnf("Cannot find the pgpool process: %v", err)
+	}
+	if c.liveness {
+		c.collectLivenessMetrics(ch, processes, pid)
+	}
+	if err != nil {
+		return
+	}
+	if c.cgroup {
+		if err := collectCgroupMetrics(ch, pid); err != nil {
+			logrus.Warnf("Cannot read the cgroup of pgpool process %d: %v", pid, err)
+		}
+	}
+}
+
+// collectLivenessMetrics exports whether the parent process runs, its
+// children and restarts. A pid of 0 means it was not found.
+func (c *ProcessCollector) collectLivenessMetrics(ch chan<- prometheus.Metric, processes map[int]procStat, pid int) {
+	parent, up := processes[pid]
+	c.mutex.Lock()
+	if up {
+		if c.lastPID != 0 && (pid != c.lastPID || parent.startTime != c.lastStartTime) {
+			c.restarts++
+		}
+		c.lastPID, c.lastStartTime = pid, parent.startTime
+	}
+	restarts := c.restarts
+	c.mutex.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(ProcessRestarts, prometheus.CounterValue, float64(restarts))
+	if !up {
+		ch <- prometheus.MustNewConstMetric(ProcessUp, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(ProcessUp, prometheus.GaugeValue, 1)
+	children := 0
+	for _, stat := range processes {
+		if stat.ppid == pid {
+			children++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(ProcessChildren, prometheus.GaugeValue, float64(children))
+	if bootTime, err := readBootTime(); err == nil {
+		ch <- prometheus.MustNewConstMetric(ProcessStartTime, prometheus.GaugeValue, float64(bootTime)+float64(parent.startTime)/clockTicks)
+	}
+}
+
+// findPID returns the pid of the pgpool parent process, from the pid file if
+// there is one, else the oldest pgpool process whose parent is no pgpool.
+func (c *ProcessCollector) findPID(processes map[int]procStat) (int, error) {
+	if len(c.pidFile) != 0 {
+		content, err := os.ReadFile(c.pidFile)
+		if err != nil {
+			return 0, err
+		}
+		fields := strings.Fields(string(content))
+		if len(fields) == 0 {
+			return 0, fmt.Errorf("pid file %s is empty", c.pidFile)
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return 0, err
+		}
+		// a stale pid file after a crash
+		if _, ok := processes[pid]; !ok {
+			return 0, fmt.Errorf("process %d of pid file %s is not running", pid, c.pidFile)
+		}
+		return pid, nil
+	}
+	found := 0
+	for pid, stat := range processes {
+		if stat.comm != pgpoolComm || processes[stat.ppid].comm == pgpoolComm {
+			continue
+		}
+		if found == 0 || stat.startTime < processes[found].startTime {
+			found = pid
+		}
+	}
+	if found == 0 {
+		return 0, fmt.Errorf("no %s process in %s", pgpoolComm, procPath)
+	}
+	return found, nil
+}
+
+// scanProcesses reads the stat of all processes, by pid.
+func scanProcesses() (map[int]procStat, error) {
+	entries, err := os.ReadDir(procPath)
+	if err != nil {
+		return nil, err
+	}
+	processes := make(map[int]procStat)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// processes may exit during the scan, zombies have exited already
+		if stat, err := readProcStat(pid); err == nil && stat.state != "Z" {
+			processes[pid] = stat
+		}
+	}
+	return processes, nil
+}
+
+type procStat struct {
+	comm  string
+	state string
+	ppid  int
+	// startTime is in clock ticks after boot
+	startTime uint64
+}
+
+// readProcStat parses /proc/<pid>/stat, whose second field is the command
+// name in parentheses, which may contain spaces.
+func readProcStat(pid int) (procStat, error) {
+	content, err := os.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return procStat{}, err
+	}
+	line := string(content)
+	start, end := strings.IndexByte(line, '('), strings.LastIndexByte(line, ')')
+	if start < 0 || end < start {
+		return procStat{}, fmt.Errorf("malformed stat of process %d", pid)
+	}
+	// fields after the command name, starting with the state (field 3)
+	fields := strings.Fields(line[end+1:])
+	if len(fields) < 20 {
+		return procStat{}, fmt.Errorf("malformed stat of process %d", pid)
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return procStat{}, err
+	}
+	startTime, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return procStat{}, err
+	}
+	return procStat{comm: line[start+1 : end], state: fields[0], ppid: ppid, startTime: startTime}, nil
+}
+
+// readBootTime returns the boot time of the host from the btime line of
+// /proc/stat, in seconds since unix epoch.
+func readBootTime() (int64, error) {
+	content, err := os.ReadFile(filepath.Join(procPath, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "btime" {
+			return strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("no btime in %s/stat", procPath)
+}
+
+// cgroupOf returns the cgroup v2 directory of the process.
+func cgroupOf(pid int) (string, error) {
+	f, err := os.Open(filepath.Join(procPath, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// the unified hierarchy is "0::/path"
+		if path := strings.TrimPrefix(scanner.Text(), "0::"); path != scanner.Text() {
+			return filepath.Join(cgroupPath, path), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("process %d is not in a cgroup v2", pid)
+}
+
+func collectCgroupMetrics(ch chan<- prometheus.Metric, pid int) error {
+	dir, err := cgroupOf(pid)
+	if err != nil {
+		return err
+	}
+	memory, err := readCgroupValue(dir, "memory.current")
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(ProcessCgroupMemory, prometheus.GaugeValue, memory)
+	// "max" if there is no limit
+	if limit, err := readCgroupValue(dir, "memory.max"); err == nil {
+		ch <- prometheus.MustNewConstMetric(ProcessCgroupMemoryLimit, prometheus.GaugeValue, limit)
+	}
+	if events, err := readCgroupKeyValues(dir, "memory.events"); err == nil {
+		ch <- prometheus.MustNewConstMetric(ProcessCgroupOOMKills, prometheus.CounterValue, events["oom_kill"])
+	}
+	cpu, err := readCgroupKeyValues(dir, "cpu.stat")
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(ProcessCgroupCPU, prometheus.CounterValue, cpu["usage_usec"]/1e6)
+	if throttled, ok := cpu["throttled_usec"]; ok {
+		ch <- prometheus.MustNewConstMetric(ProcessCgroupCPUThrottled, prometheus.CounterValue, throttled/1e6)
+	}
+	return nil
+}
+
+func readCgroupValue(dir, name string) (float64, error) {
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(content)), 64)
+}
+
+// readCgroupKeyValues reads a cgroup file of "key value" lines.
+func readCgroupKeyValues(dir, name string) (map[string]float64, error) {
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]float64)
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed %s: %v", name, err)
+		}
+		values[fields[0]] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+)
+
+const (
+	recordTimeFormat = "20060102T150405.000000000Z"
+	// a recording is the output of the command, or the error message of a
+	// failed command
+	recordOutputExt = ".out"
+	recordErrorExt  = ".err"
+)
+
+// pcpExecutor returns the executor of the PCP clients of the pgpool at
+// hostname: the pcp binaries, run as the -pcp.run-as user if set, SQL in
+// CollectModeSQL, or a replay, optionally recorded, with the configured
+// faults.
+func pcpExecutor(hostname string) (pgpool2.Executor, error) {
+	var executor pgpool2.Executor = pgpool2.ExecExecutor{Credential: runAsCredential}
+	if len(*runAsUser) != 0 && *runAsMethod == RunAsSudo {
+		executor = sudoExecutor{executor: executor, user: *runAsUser}
+	}
+	if *collectMode == CollectModeSQL {
+		var err error
+		if executor, err = newSQLExecutor(hostname); err != nil {
+			return nil, err
+		}
+	}
+	if len(*replayDir) != 0 {
+		executor = newReplayExecutor(*replayDir)
+	}
+	if len(*recordDir) != 0 {
+		executor = recordExecutor{executor: executor, dir: *recordDir}
+	}
+	return withFaults(executor), nil
+}
+
+// recordingKey identifies a PCP command by the command and its arguments, left
+// out the connection arguments.
+func recordingKey(cmd string, args []string) string {
+	key := []string{filepath.Base(cmd)}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--username=") || strings.HasPrefix(arg, "--host=") ||
+			strings.HasPrefix(arg, "--port=") || arg == "--no-password" {
+			continue
+		}
+		key = append(key, strings.TrimLeft(arg, "-"))
+	}
+	return strings.Join(key, "_")
+}
+
+// recordingDir returns the directory of the recordings of the pgpool given
+// in args.
+func recordingDir(dir string, args []string) string {
+	var host, port string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--host=") {
+			host = strings.TrimPrefix(arg, "--host=")
+		} else if strings.HasPrefix(arg, "--port=") {
+			port = strings.TrimPrefix(arg, "--port=")
+		}
+	}
+	return filepath.Join(dir, host+"_"+port)
+}
+
+// recordExecutor writes the raw output of every PCP command to a file named
+// after the time and the command, for bug reports about parsing.
+type recordExecutor struct {
+	executor pgpool2.Executor
+	dir      string
+}
+
+func (e recordExecutor) Exec(ctx context.Context, parse func(io.Reader) error, cmd string, args []string, env []string) error {
+	dir := recordingDir(e.dir, args)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, time.Now().UTC().Format(recordTimeFormat)+"_"+recordingKey(cmd, args))
+	f, err := os.OpenFile(name+recordOutputExt, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = e.executor.Exec(ctx, func(r io.Reader) error {
+		return parse(io.TeeReader(r, f))
+	}, cmd, args, env)
+	f.Close()
+	if err != nil {
+		os.Remove(name + recordOutputExt)
+		if writeErr := os.WriteFile(name+recordErrorExt, []byte(err.Error()), 0600); writeErr != nil {
+			return fmt.Errorf("%v (recording failed: %v)", err, writeErr)
+		}
+	}
+	return err
+}
+
+// replayExecutor serves PCP commands from the recordings of a recordExecutor
+// instead of running them. Each run returns the next recording of the command,
+// starting over after the last one.
+type replayExecutor struct {
+	dir string
+
+	mutex sync.Mutex
+	next  map[string]int
+}
+
+func newReplayExecutor(dir string) *replayExecutor {
+	return &replayExecutor{
+		dir:  dir,
+		next: make(map[string]int),
+	}
+}
+
+func (e *replayExecutor) Exec(ctx context.Context, parse func(io.Reader) error, cmd string, args []string, env []string) error {
+	dir := recordingDir(e.dir, args)
+	key := recordingKey(cmd, args)
+	recordings, err := filepath.Glob(filepath.Join(dir, "*_"+key+".*"))
+	if err != nil {
+		return err
+	}
+	if len(recordings) == 0 {
+		return fmt.Errorf("no recording of %s in %s", key, dir)
+	}
+	sort.Strings(recordings)
+
+	e.mutex.Lock()
+	name := recordings[e.next[dir+key]%len(recordings)]
+	e.next[dir+key]++
+	e.mutex.Unlock()
+
+	if strings.HasSuffix(name, recordErrorExt) {
+		message, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		return errors.New(string(message))
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return parse(f)
+}
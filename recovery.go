@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const recoveryPollInterval = 5 * time.Second
+
+type recoveryNode struct {
+	target string
+	id     int
+}
+
+// RecoveryTracker runs pcp_recovery_node for nodes given to its admin API and
+// polls their status while the recovery runs, as pgpool reports nothing until
+// the base backup of the primary is done.
+type RecoveryTracker struct {
+	targets      []*Target
+	pollInterval time.Duration
+	mutex        sync.Mutex
+	running      map[recoveryNode]time.Time
+	inProgress   *prometheus.GaugeVec
+	nodeStatus   *prometheus.GaugeVec
+	durations    *prometheus.HistogramVec
+}
+
+func NewRecoveryTracker(targets []*Target) *RecoveryTracker {
+	return &RecoveryTracker{
+		targets:      targets,
+		pollInterval: recoveryPollInterval,
+		running:      make(map[recoveryNode]time.Time),
+		inProgress: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "recovery_in_progress",
+				Help:      "Whether an online recovery of the backend node started through the admin API is running",
+			},
+			[]string{"target", "id"},
+		),
+		nodeStatus: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "recovery_node_status",
+				Help:      "Status of the backend node polled during its last online recovery",
+			},
+			[]string{"target", "id"},
+		),
+		durations: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "recovery_duration_seconds",
+				Help:      "Duration of the online recoveries started through the admin API by result",
+				Buckets:   []float64{30, 60, 300, 600, 1800, 3600, 7200, 14400, 28800},
+			},
+			[]string{"result"},
+		),
+	}
+}
+
+func (t *RecoveryTracker) Describe(ch chan<- *prometheus.Desc) {
+	t.inProgress.Describe(ch)
+	t.nodeStatus.Describe(ch)
+	t.durations.Describe(ch)
+}
+
+func (t *RecoveryTracker) Collect(ch chan<- prometheus.Metric) {
+	t.inProgress.Collect(ch)
+	t.nodeStatus.Collect(ch)
+	t.durations.Collect(ch)
+}
+
+type apiRecovery struct {
+	Target  string    `json:"target"`
+	Node    int       `json:"node"`
+	Started time.Time `json:"started"`
+}
+
+// ServeHTTP starts the recovery of the node given by the node and target
+// parameters on POST and lists the running recoveries on GET. The recovery
+// runs in the background, its outcome is logged and exported.
+func (t *RecoveryTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		target := findTarget(t.targets, r.URL.Query().Get("target"))
+		if target == nil {
+			http.Error(w, fmt.Sprintf("Unknown target %q", r.URL.Query().Get("target")), http.StatusBadRequest)
+			return
+		}
+		id, err := strconv.Atoi(r.URL.Query().Get("node"))
+		if err != nil || id < 0 {
+			http.Error(w, fmt.Sprintf("Invalid node id %q", r.URL.Query().Get("node")), http.StatusBadRequest)
+			return
+		}
+		if !t.start(target, id) {
+			http.Error(w, fmt.Sprintf("Recovery of node %d is already running", id), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	t.mutex.Lock()
+	result := make([]apiRecovery, 0, len(t.running))
+	for node, started := range t.running {
+		result = append(result, apiRecovery{Target: node.target, Node: node.id, Started: started})
+	}
+	t.mutex.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Target != result[j].Target {
+			return result[i].Target < result[j].Target
+		}
+		return result[i].Node < result[j].Node
+	})
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(apiResponse{
+		Status: "success",
+		Data: map[string]interface{}{
+			"recoveries": result,
+		},
+	})
+	if err != nil {
+		logrus.Errorf("Cannot write recovery API response: %v", err)
+	}
+}
+
+// start runs the recovery of the node unless one is running already.
+func (t *RecoveryTracker) start(target *Target, id int) bool {
+	node := recoveryNode{target: target.Name, id: id}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, ok := t.running[node]; ok {
+		return false
+	}
+	t.running[node] = time.Now()
+	t.inProgress.WithLabelValues(node.target, strconv.Itoa(id)).Set(1)
+	go t.recover(target, node)
+	return true
+}
+
+func (t *RecoveryTracker) recover(target *Target, node recoveryNode) {
+	logger := logrus.WithField("node", node.id)
+	if len(node.target) != 0 {
+		logger = logger.WithField("target", node.target)
+	}
+	client := target.activeEndpoint().client
+	logger.Info("Starting online recovery")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		t.poll(ctx, target, node, logger)
+	}()
+	err := client.ExecRecoveryNodeContext(context.Background(), node.id)
+	cancel()
+	<-polled
+	// the status the recovery left the node in
+	ctx, cancel = context.WithTimeout(context.Background(), t.pollInterval)
+	t.pollOnce(ctx, target, node, logger, "")
+	cancel()
+
+	t.mutex.Lock()
+	duration := time.Since(t.running[node])
+	delete(t.running, node)
+	t.inProgress.WithLabelValues(node.target, strconv.Itoa(node.id)).Set(0)
+	t.mutex.Unlock()
+	result := "success"
+	if err != nil {
+		result = "failure"
+		logger.Errorf("Online recovery failed after %s: %v", duration, err)
+	} else {
+		logger.Infof("Online recovery finished after %s", duration)
+	}
+	t.durations.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// poll exports the status of the node until ctx is done and logs its changes.
+func (t *RecoveryTracker) poll(ctx context.Context, target *Target, node recoveryNode, logger *logrus.Entry) {
+	ticker := time.NewTicker(t.pollInterval)
+	defer ticker.Stop()
+	lastStatus := ""
+	for {
+		lastStatus = t.pollOnce(ctx, target, node, logger, lastStatus)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollOnce exports the status of the node, logs it if it differs from
+// lastStatus and returns it.
+func (t *RecoveryTracker) pollOnce(ctx context.Context, target *Target, node recoveryNode, logger *logrus.Entry, lastStatus string) string {
+	nodeInfo, err := target.activeEndpoint().client.ExecNodeInfoContext(ctx, node.id)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Warnf("Cannot poll the node during online recovery: %v", err)
+		}
+		return lastStatus
+	}
+	t.nodeStatus.WithLabelValues(node.target, strconv.Itoa(node.id)).Set(float64(nodeInfo.StatusCode))
+	if nodeInfo.Status != lastStatus {
+		logger.Infof("Node status: %s", nodeInfo.Status)
+	}
+	return nodeInfo.Status
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// alertingRules are the example rules of contrib, built into the binary so
+// that they always match the metric names of this version.
+//
+//go:embed contrib/prometheus-alerts/pgpool2.yml
+var alertingRules []byte
+
+// rulesHandler serves the Prometheus rule file.
+func rulesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(alertingRules)
+	})
+}
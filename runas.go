@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+)
+
+const (
+	// RunAsSudo runs the PCP commands with sudo as the -pcp.run-as user
+	RunAsSudo = "sudo"
+	// RunAsSetuid switches to the -pcp.run-as user before running the PCP
+	// commands, which needs the exporter to run as root or with the
+	// CAP_SETUID and CAP_SETGID capabilities
+	RunAsSetuid = "setuid"
+
+	sudoPath = "/usr/bin/sudo"
+)
+
+// runAsCredential is the user of the PCP commands with RunAsSetuid, nil
+// otherwise.
+var runAsCredential *pgpool2.Credential
+
+// sudoExecutor runs the PCP commands through sudo as another user. The
+// environment of the commands, which holds PCPPASSFILE, is passed as
+// VAR=value arguments, which the sudoers rule must allow with SETENV.
+type sudoExecutor struct {
+	executor pgpool2.Executor
+	user     string
+}
+
+func (e sudoExecutor) Exec(ctx context.Context, parse func(io.Reader) error, cmd string, args []string, env []string) error {
+	// never prompt for a password
+	sudoArgs := append([]string{"-n", "-u", e.user}, env...)
+	sudoArgs = append(append(sudoArgs, cmd), args...)
+	return e.executor.Exec(ctx, parse, sudoPath, sudoArgs, []string{})
+}
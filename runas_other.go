@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+)
+
+func userCredential(name string) (*pgpool2.Credential, error) {
+	return nil, errors.New("setuid run-as not supported on this platform")
+}
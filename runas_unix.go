@@ -0,0 +1,39 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+)
+
+// userCredential returns the ids and groups of the user to run the PCP
+// commands as with RunAsSetuid.
+func userCredential(name string) (*pgpool2.Credential, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected uid %q of user %s", u.Uid, name)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected gid %q of user %s", u.Gid, name)
+	}
+	credential := &pgpool2.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, groupID := range groupIDs {
+		if id, err := strconv.ParseUint(groupID, 10, 32); err == nil {
+			credential.Groups = append(credential.Groups, uint32(id))
+		}
+	}
+	return credential, nil
+}
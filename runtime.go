@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ExporterChildProcessesStarted = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "child_processes_started_total"),
+		"Number of child processes the exporter started by command, like the PCP commands",
+		[]string{"command"}, nil,
+	)
+	ExporterChildProcessesStderr = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "child_processes_stderr_total"),
+		"Number of child processes of the exporter that printed on stderr by command, the output is served on /debug/stderr of the admin interface",
+		[]string{"command"}, nil,
+	)
+	ExporterExecFailures = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "exec_failures_total"),
+		"Number of commands the exporter could not start by command and reason (not_found, missing_interpreter, permission_denied, wrong_architecture, exec_format or other)",
+		[]string{"command", "reason"}, nil,
+	)
+	ExporterChildProcesses = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "child_processes"),
+		"Number of child processes of the exporter that have not been waited for",
+		nil, nil,
+	)
+	ExporterOpenFDsNearLimit = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "open_fds_near_limit"),
+		"Whether the exporter uses at least 80% of its open files limit, after which PCP commands start to fail",
+		nil, nil,
+	)
+	ExporterOpenFDs = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "open_fds"),
+		"Number of open file descriptors of the exporter by type (pipe, socket, file or other)",
+		[]string{"type"}, nil,
+	)
+)
+
+// fdLimitWarning is the share of the open files limit the exporter warns at.
+const fdLimitWarning = 0.8
+
+var byteUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"B", 1},
+}
+
+// parseBytes parses a size in bytes with an optional KiB, MiB or GiB suffix,
+// like the GOMEMLIMIT environment variable.
+func parseBytes(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	factor := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			factor = unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * factor, nil
+}
+
+// applyRuntimeLimits sets the soft memory limit and the GC target of the Go
+// runtime. Empty or zero values keep the defaults, which honour the GOMEMLIMIT
+// and GOGC environment variables.
+func applyRuntimeLimits(memoryLimit string, gcPercent int) error {
+	if len(memoryLimit) != 0 {
+		limit, err := parseBytes(memoryLimit)
+		if err != nil {
+			return fmt.Errorf("invalid memory limit: %v", err)
+		}
+		debug.SetMemoryLimit(limit)
+	}
+	if gcPercent != 0 {
+		debug.SetGCPercent(gcPercent)
+	}
+	return nil
+}
+
+// newMemoryLimitCollector exports the soft memory limit of the Go runtime.
+func newMemoryLimitCollector() prometheus.Collector {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "exporter",
+			Name:      "memory_limit_bytes",
+			Help:      "Soft memory limit of the Go runtime (math.MaxInt64 if there is none)",
+		},
+		func() float64 {
+			return float64(debug.SetMemoryLimit(-1))
+		},
+	)
+}
+
+// selfCollector exports the child processes and file descriptors of the
+// exporter, which leak if a child process is not waited for.
+type selfCollector struct{}
+
+func (selfCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ExporterChildProcessesStarted
+	ch <- ExporterChildProcessesStderr
+	ch <- ExporterExecFailures
+	ch <- ExporterChildProcesses
+	ch <- ExporterOpenFDs
+	ch <- ExporterOpenFDsNearLimit
+}
+
+func (selfCollector) Collect(ch chan<- prometheus.Metric) {
+	started, running := pgpool2.ProcessCounts()
+	for command, n := range started {
+		ch <- prometheus.MustNewConstMetric(ExporterChildProcessesStarted, prometheus.CounterValue, float64(n), command)
+	}
+	for command, record := range pgpool2.LastStderr() {
+		ch <- prometheus.MustNewConstMetric(ExporterChildProcessesStderr, prometheus.CounterValue, float64(record.Count), command)
+	}
+	for failure, n := range pgpool2.ExecFailureCounts() {
+		ch <- prometheus.MustNewConstMetric(ExporterExecFailures, prometheus.CounterValue, float64(n), failure.Command, failure.Reason)
+	}
+	ch <- prometheus.MustNewConstMetric(ExporterChildProcesses, prometheus.GaugeValue, float64(running))
+
+	// not available on other platforms than Linux
+	fds, err := openFDs(filepath.Join(procPath, "self", "fd"))
+	if err != nil {
+		return
+	}
+	open := 0
+	for _, fdType := range []string{"pipe", "socket", "file", "other"} {
+		ch <- prometheus.MustNewConstMetric(ExporterOpenFDs, prometheus.GaugeValue, float64(fds[fdType]), fdType)
+		open += fds[fdType]
+	}
+	limit, ok := openFilesLimit()
+	if !ok {
+		return
+	}
+	nearLimit := 0.0
+	if float64(open) >= fdLimitWarning*float64(limit) {
+		logrus.Warnf("The exporter uses %d of %d open files (%d pipes), raise the limit or look for leaked child processes", open, limit, fds["pipe"])
+		nearLimit = 1
+	}
+	ch <- prometheus.MustNewConstMetric(ExporterOpenFDsNearLimit, prometheus.GaugeValue, nearLimit)
+}
+
+// openFDs counts the file descriptors in the fd directory of a process by
+// the type their link points to.
+func openFDs(dir string) (map[string]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	fds := make(map[string]int)
+	for _, entry := range entries {
+		// closed since the directory was read
+		target, err := os.Readlink(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(target, "pipe:"):
+			fds["pipe"]++
+		case strings.HasPrefix(target, "socket:"):
+			fds["socket"]++
+		case strings.HasPrefix(target, "/"):
+			fds["file"]++
+		default:
+			fds["other"]++
+		}
+	}
+	return fds, nil
+}
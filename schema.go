@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+var (
+	durationType   = reflect.TypeOf(time.Duration(0))
+	nodeIDListType = reflect.TypeOf(NodeIDList(nil))
+)
+
+// configSchema returns the JSON Schema of the config file, derived from the
+// types it is decoded into, so that it cannot drift from what LoadConfig
+// accepts. LoadConfig checks the file against it with validateConfigSchema;
+// the checks of Config.Validate are not part of it.
+func configSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = exporterName + " config file"
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case durationType:
+		return map[string]interface{}{
+			"type":        "string",
+			"pattern":     `^(0|([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+)$`,
+			"description": "Go duration, e.g. 30s or 1m30s",
+		}
+	case nodeIDListType:
+		return map[string]interface{}{
+			"oneOf": []interface{}{
+				map[string]interface{}{
+					"type":        "array",
+					"items":       map[string]interface{}{"type": "integer", "minimum": 0},
+					"description": "list of node ids",
+				},
+				map[string]interface{}{
+					"type":        "string",
+					"pattern":     `^\s*[0-9]+(\s*-\s*[0-9]+)?(\s*,\s*[0-9]+(\s*-\s*[0-9]+)?)*\s*$`,
+					"description": "node ids and ranges, e.g. 0-2,5",
+				},
+			},
+		}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if len(name) == 0 || name == "-" {
+				continue
+			}
+			properties[name] = typeSchema(field.Type)
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": typeSchema(t.Elem()),
+		}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
+
+// printConfigSchema writes the JSON Schema of the config file for editors
+// and CI checks.
+func printConfigSchema(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(configSchema())
+}
+
+// yaml11Bools are the booleans of YAML 1.1 besides true and false, which the
+// config decoder still accepts.
+var yaml11Bools = map[string]bool{"y": true, "yes": true, "on": true, "n": true, "no": true, "off": true}
+
+// validateConfigSchema checks the config file against configSchema and
+// returns every mismatch with its line and path, e.g. "line 5:
+// targets[0].port: expected integer". Syntax errors are left to the decoder.
+func validateConfigSchema(content []byte) error {
+	var document yamlv3.Node
+	if err := yamlv3.Unmarshal(content, &document); err != nil {
+		return nil
+	}
+	var problems []string
+	validateNode(&document, configSchema(), "", &problems)
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+func validateNode(node *yamlv3.Node, schema map[string]interface{}, path string, problems *[]string) {
+	switch node.Kind {
+	case 0:
+		return
+	case yamlv3.DocumentNode:
+		for _, content := range node.Content {
+			validateNode(content, schema, path, problems)
+		}
+		return
+	case yamlv3.AliasNode:
+		validateNode(node.Alias, schema, path, problems)
+		return
+	}
+	if node.Kind == yamlv3.ScalarNode && node.Tag == "!!null" {
+		// the decoder keeps the zero value
+		return
+	}
+	report := func(format string, args ...interface{}) {
+		where := path
+		if len(where) == 0 {
+			where = "top level"
+		}
+		*problems = append(*problems, fmt.Sprintf("line %d: %s: %s", node.Line, where, fmt.Sprintf(format, args...)))
+	}
+	if alternatives, ok := schema["oneOf"].([]interface{}); ok {
+		for _, alternative := range alternatives {
+			var mismatches []string
+			validateNode(node, alternative.(map[string]interface{}), path, &mismatches)
+			if len(mismatches) == 0 {
+				return
+			}
+		}
+		report("expected %s", schemaDescription(alternatives))
+		return
+	}
+	switch schema["type"] {
+	case "object":
+		if node.Kind != yamlv3.MappingNode {
+			report("expected a mapping")
+			return
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Tag == "!!merge" {
+				continue
+			}
+			keyPath := key.Value
+			if len(path) != 0 {
+				keyPath = path + "." + key.Value
+			}
+			if property, ok := properties[key.Value]; ok {
+				validateNode(value, property.(map[string]interface{}), keyPath, problems)
+			} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				validateNode(value, additional, keyPath, problems)
+			} else {
+				*problems = append(*problems, fmt.Sprintf("line %d: %s: unknown key", key.Line, keyPath))
+			}
+		}
+	case "array":
+		if node.Kind != yamlv3.SequenceNode {
+			report("expected a list")
+			return
+		}
+		items := schema["items"].(map[string]interface{})
+		for i, item := range node.Content {
+			validateNode(item, items, fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	case "boolean":
+		if node.Kind != yamlv3.ScalarNode || (node.Tag != "!!bool" && !yaml11Bools[strings.ToLower(node.Value)]) {
+			report("expected true or false")
+		}
+	case "integer":
+		if node.Kind != yamlv3.ScalarNode || node.Tag != "!!int" {
+			report("expected an integer")
+			return
+		}
+		if minimum, ok := schema["minimum"].(int); ok {
+			if value, err := strconv.ParseInt(node.Value, 0, 64); err == nil && value < int64(minimum) {
+				report("must be at least %d", minimum)
+			}
+		}
+	case "number":
+		if node.Kind != yamlv3.ScalarNode || (node.Tag != "!!int" && node.Tag != "!!float") {
+			report("expected a number")
+		}
+	case "string":
+		// the decoder takes any scalar as a string, e.g. a numeric password
+		if node.Kind != yamlv3.ScalarNode {
+			report("expected a string")
+			return
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(node.Value) {
+			if description, ok := schema["description"].(string); ok {
+				report("expected %s", description)
+			} else {
+				report("does not match %s", pattern)
+			}
+		}
+	}
+}
+
+// schemaDescription describes the alternatives of a oneOf schema for errors.
+func schemaDescription(alternatives []interface{}) string {
+	var descriptions []string
+	for _, alternative := range alternatives {
+		schema := alternative.(map[string]interface{})
+		if description, ok := schema["description"].(string); ok {
+			descriptions = append(descriptions, description)
+		} else {
+			descriptions = append(descriptions, fmt.Sprintf("%s", schema["type"]))
+		}
+	}
+	return strings.Join(descriptions, " or ")
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var scriptNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// scriptRun identifies a run of a script, e.g. failover_command is run once
+// per failed node.
+type scriptRun struct {
+	script string
+	node   string
+}
+
+// ScriptReporter receives the start and end of pgpool's failover_command,
+// follow_primary_command and similar scripts, which pgpool runs without
+// reporting anything about them over PCP.
+type ScriptReporter struct {
+	mutex     sync.Mutex
+	starts    map[scriptRun]time.Time
+	running   *prometheus.GaugeVec
+	runs      *prometheus.CounterVec
+	exitCodes *prometheus.GaugeVec
+	durations *prometheus.HistogramVec
+}
+
+func NewScriptReporter() *ScriptReporter {
+	return &ScriptReporter{
+		starts: make(map[scriptRun]time.Time),
+		running: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "script_running",
+				Help:      "Number of runs of the pgpool script that reported their start but not their end",
+			},
+			[]string{"script"},
+		),
+		runs: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Name:      "script_runs_total",
+				Help:      "Number of finished runs of the pgpool script by result (success for exit code 0, failure otherwise)",
+			},
+			[]string{"script", "result"},
+		),
+		exitCodes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Name:      "script_last_exit_code",
+				Help:      "Exit code of the last finished run of the pgpool script",
+			},
+			[]string{"script"},
+		),
+		durations: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Name:      "script_duration_seconds",
+				Help:      "Duration of the runs of the pgpool script that reported their start and end",
+				Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800},
+			},
+			[]string{"script"},
+		),
+	}
+}
+
+func (s *ScriptReporter) Describe(ch chan<- *prometheus.Desc) {
+	s.running.Describe(ch)
+	s.runs.Describe(ch)
+	s.exitCodes.Describe(ch)
+	s.durations.Describe(ch)
+}
+
+func (s *ScriptReporter) Collect(ch chan<- prometheus.Metric) {
+	s.running.Collect(ch)
+	s.runs.Collect(ch)
+	s.exitCodes.Collect(ch)
+	s.durations.Collect(ch)
+}
+
+// ServeHTTP takes a POST with the script name, the event start or end, the
+// exit code for end and optionally the node id the script was run for.
+func (s *ScriptReporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	run := scriptRun{script: query.Get("script"), node: query.Get("node")}
+	if !scriptNameRegexp.MatchString(run.script) {
+		http.Error(w, fmt.Sprintf("Invalid script name %q", run.script), http.StatusBadRequest)
+		return
+	}
+	logger := logrus.WithField("script", run.script)
+	if len(run.node) != 0 {
+		logger = logger.WithField("node", run.node)
+	}
+	switch query.Get("event") {
+	case "start":
+		s.start(run)
+		logger.Info("Pgpool script started")
+	case "end":
+		exitCode, err := strconv.Atoi(query.Get("exit_code"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid exit code %q", query.Get("exit_code")), http.StatusBadRequest)
+			return
+		}
+		duration, started := s.end(run, exitCode)
+		if started {
+			logger = logger.WithField("duration", duration)
+		}
+		if exitCode != 0 {
+			logger.Warnf("Pgpool script failed with exit code %d", exitCode)
+		} else {
+			logger.Info("Pgpool script finished")
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Invalid event %q, must be start or end", query.Get("event")), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *ScriptReporter) start(run scriptRun) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// a run that never reported its end is replaced
+	if _, ok := s.starts[run]; !ok {
+		s.running.WithLabelValues(run.script).Inc()
+	}
+	s.starts[run] = time.Now()
+}
+
+// end records the end of the run and returns its duration if its start was
+// reported.
+func (s *ScriptReporter) end(run scriptRun, exitCode int) (time.Duration, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := "success"
+	if exitCode != 0 {
+		result = "failure"
+	}
+	s.runs.WithLabelValues(run.script, result).Inc()
+	s.exitCodes.WithLabelValues(run.script).Set(float64(exitCode))
+	started, ok := s.starts[run]
+	if !ok {
+		return 0, false
+	}
+	delete(s.starts, run)
+	s.running.WithLabelValues(run.script).Dec()
+	duration := time.Since(started)
+	s.durations.WithLabelValues(run.script).Observe(duration.Seconds())
+	return duration, true
+}
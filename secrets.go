@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// dockerSecretScheme refers to a Docker secret by name in a flag value
+	dockerSecretScheme = "docker-secret://"
+	dockerSecretsDir   = "/run/secrets"
+)
+
+// dockerSecretPath returns the path of the Docker secret value refers to, and
+// false if it refers to none.
+func dockerSecretPath(value string) (string, bool, error) {
+	if !strings.HasPrefix(value, dockerSecretScheme) {
+		return "", false, nil
+	}
+	name := strings.TrimPrefix(value, dockerSecretScheme)
+	if len(name) == 0 || strings.ContainsRune(name, '/') || name == "." || name == ".." {
+		return "", false, fmt.Errorf("invalid Docker secret name %q", name)
+	}
+	return filepath.Join(dockerSecretsDir, name), true, nil
+}
+
+// resolveSecretPath returns the path of the Docker secret a path flag refers
+// to, or the path itself.
+func resolveSecretPath(value string) (string, error) {
+	path, ok, err := dockerSecretPath(value)
+	if err != nil || !ok {
+		return value, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// resolveSecret returns the content of the Docker secret a flag refers to,
+// without the trailing newline editors add, or the value itself.
+func resolveSecret(value string) (string, error) {
+	path, ok, err := dockerSecretPath(value)
+	if err != nil || !ok {
+		return value, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
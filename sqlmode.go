@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+)
+
+const (
+	CollectModePCP = "pcp"
+	CollectModeSQL = "sql"
+)
+
+// sqlDataSource is the resolved -sql.dsn of CollectModeSQL.
+var sqlDataSource string
+
+// sqlConnectionString returns the lib/pq connection string of the SQL
+// listener of the pgpool at hostname, which a host in the data source
+// overrides.
+func sqlConnectionString(dataSource, hostname string) (string, error) {
+	if strings.HasPrefix(dataSource, "postgres://") || strings.HasPrefix(dataSource, "postgresql://") {
+		var err error
+		dataSource, err = pq.ParseURL(dataSource)
+		if err != nil {
+			return "", err
+		}
+	}
+	// later values take precedence
+	return fmt.Sprintf("host='%s' %s", strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(hostname), dataSource), nil
+}
+
+// newSQLExecutor returns the executor answering the PCP commands with SQL on
+// the listener of the pgpool at hostname.
+func newSQLExecutor(hostname string) (pgpool2.Executor, error) {
+	connection, err := sqlConnectionString(sqlDataSource, hostname)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := pq.NewConnector(connection)
+	if err != nil {
+		return nil, err
+	}
+	return pgpool2.SQLExecutor{Connector: connector}, nil
+}
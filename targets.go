@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TargetConfig is one pgpool PCP endpoint in multi-target mode. Empty fields
+// fall back to the -pcp.* flags.
+type TargetConfig struct {
+	Name     string `yaml:"name"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	PassFile string `yaml:"passfile"`
+	// Endpoints replaces Host with several host[:port] of the same cluster,
+	// in order of preference
+	Endpoints []string `yaml:"endpoints"`
+	// NodeIDs replaces the node_ids of the config file for this target
+	NodeIDs NodeIDList `yaml:"node_ids"`
+	// ClusterMode replaces -pgpool.cluster-mode for this target
+	ClusterMode string `yaml:"cluster_mode"`
+	// Timezone replaces -pgpool.timezone for this target
+	Timezone string `yaml:"timezone"`
+	// VIPAddress replaces -watchdog.vip-address for this target
+	VIPAddress string `yaml:"vip_address"`
+	// DatabaseConnectionLimits replaces the database_connection_limits of
+	// the config file for this target
+	DatabaseConnectionLimits map[string]int `yaml:"database_connection_limits"`
+	// MaintenanceNodes replaces the maintenance_nodes of the config file for
+	// this target
+	MaintenanceNodes NodeIDList `yaml:"maintenance_nodes"`
+	// NodeInfoOverrides replaces the node_info_overrides of the config file
+	// for this target, e.g. for a pgpool of another version
+	NodeInfoOverrides map[string]string `yaml:"node_info_overrides"`
+	// Version is the pgpool version of the target, e.g. 4.3.5, which is then
+	// not detected with the local pcp tools
+	Version string `yaml:"version"`
+}
+
+// Options returns the PCP client options of the target on top of defaults.
+func (t TargetConfig) Options(defaults pgpool2.Options) pgpool2.Options {
+	options := defaults
+	if len(t.Host) != 0 {
+		options.Hostname = t.Host
+	}
+	if t.Port != 0 {
+		options.Port = t.Port
+	}
+	if len(t.Username) != 0 {
+		options.Username = t.Username
+	}
+	// credentials of a target replace the default ones as a whole
+	if len(t.Password) != 0 || len(t.PassFile) != 0 {
+		options.Password = t.Password
+		options.PassFile = t.PassFile
+	}
+	return options
+}
+
+// EndpointOptions returns the PCP client options of every endpoint of the
+// target, the preferred one first.
+func (t TargetConfig) EndpointOptions(defaults pgpool2.Options) ([]pgpool2.Options, error) {
+	options := t.Options(defaults)
+	if len(t.Endpoints) == 0 {
+		return []pgpool2.Options{options}, nil
+	}
+	endpoints := make([]pgpool2.Options, 0, len(t.Endpoints))
+	for _, endpoint := range t.Endpoints {
+		endpointOptions := options
+		endpointOptions.Hostname = endpoint
+		if host, port, err := net.SplitHostPort(endpoint); err == nil {
+			portInt, err := strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port in endpoint %q", endpoint)
+			}
+			endpointOptions.Hostname = host
+			endpointOptions.Port = portInt
+		}
+		endpoints = append(endpoints, endpointOptions)
+	}
+	return endpoints, nil
+}
+
+// Target is a pgpool instance the exporter collects from. The target given by
+// the -pcp.* flags has no name and its metrics carry no target label.
+//
+// A target can have several PCP endpoints of the same cluster, e.g. all
+// watchdog members. Each scrape is then served by the first endpoint, in order
+// of preference, that answers. The counters and detected state of the target
+// are kept across the endpoints.
+type Target struct {
+	Name      string
+	endpoints []targetEndpoint
+	mutex     sync.Mutex
+	active    int
+	// maintenance is shared by the exporters of all endpoints
+	maintenance *MaintenanceNodes
+}
+
+type targetEndpoint struct {
+	address  string
+	client   *pgpool2.Client
+	exporter *Exporter
+}
+
+func NewTarget(name string, options []pgpool2.Options, exporterOptions ExporterOptions) (*Target, error) {
+	return newTarget(name, options, exporterOptions, pcpExecutor)
+}
+
+// newTarget is NewTarget with the executor of the PCP commands of each
+// endpoint, by hostname, from newExecutor.
+func newTarget(name string, options []pgpool2.Options, exporterOptions ExporterOptions, newExecutor func(hostname string) (pgpool2.Executor, error)) (*Target, error) {
+	target := &Target{Name: name, maintenance: exporterOptions.MaintenanceNodes}
+	for _, endpointOptions := range options {
+		// the password file written by the exporter is only readable by
+		// the exporter user
+		if len(*runAsUser) != 0 && len(endpointOptions.PassFile) == 0 {
+			target.clean()
+			err := errors.New("-pcp.run-as requires a passfile")
+			if len(name) != 0 {
+				return nil, fmt.Errorf("target %s: %v", name, err)
+			}
+			return nil, err
+		}
+		var client *pgpool2.Client
+		executor, err := newExecutor(endpointOptions.Hostname)
+		if err == nil {
+			clientOptions := []pgpool2.ClientOption{
+				pgpool2.WithOptions(endpointOptions),
+				pgpool2.WithExecutor(executor),
+				pgpool2.WithNodeInfoOverrides(exporterOptions.NodeInfoOverrides),
+			}
+			if exporterOptions.Version != nil {
+				clientOptions = append(clientOptions, pgpool2.WithVersion(*exporterOptions.Version))
+			}
+			client, err = pgpool2.New(clientOptions...)
+		}
+		if err != nil {
+			target.clean()
+			if len(name) != 0 {
+				return nil, fmt.Errorf("target %s: %v", name, err)
+			}
+			return nil, err
+		}
+		exporter, err := NewExporter(client, exporterOptions)
+		if err != nil {
+			client.Clean()
+			target.clean()
+			return nil, err
+		}
+		address := net.JoinHostPort(endpointOptions.Hostname, strconv.Itoa(endpointOptions.Port))
+		if len(name) != 0 {
+			exporter.logger = exporter.logger.WithField("target", name)
+		}
+		if len(options) > 1 {
+			exporter.logger = exporter.logger.WithField("endpoint", address)
+		}
+		exporter.endpoint = address
+		target.endpoints = append(target.endpoints, targetEndpoint{
+			address:  address,
+			client:   client,
+			exporter: exporter,
+		})
+	}
+	// the endpoints are members of one watchdog cluster: their exporters
+	// share what they keep between scrapes, so that only the PCP client
+	// changes on a failover
+	for _, endpoint := range target.endpoints {
+		endpoint.exporter.exporterState = target.endpoints[0].exporter.exporterState
+		for _, peer := range target.endpoints {
+			if peer.exporter != endpoint.exporter {
+				endpoint.exporter.watchdogPeers = append(endpoint.exporter.watchdogPeers, watchdogPeer{endpoint: peer.address, client: peer.client})
+			}
+		}
+	}
+	return target, nil
+}
+
+// activeEndpoint returns the endpoint that served the last scrape, the
+// preferred one before the first scrape.
+func (t *Target) activeEndpoint() targetEndpoint {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.endpoints[t.active]
+}
+
+// selectEndpoint returns the first endpoint that answers pcp_node_count, or
+// the preferred one if none does, so that its collection reports the target
+// as down. Every endpoint tried gets an equal share of the time left.
+func (t *Target) selectEndpoint(ctx context.Context) (int, bool) {
+	if len(t.endpoints) == 1 {
+		return 0, true
+	}
+	for i, endpoint := range t.endpoints {
+		probeCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			share := time.Until(deadline) / time.Duration(len(t.endpoints)-i)
+			probeCtx, cancel = context.WithTimeout(ctx, share)
+		}
+		_, err := endpoint.client.ExecNodeCountContext(probeCtx)
+		cancel()
+		if err == nil {
+			return i, true
+		}
+		endpoint.exporter.logger.Warnf("PCP endpoint is unreachable: %v", err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return 0, false
+}
+
+func (t *Target) clean() {
+	for _, endpoint := range t.endpoints {
+		endpoint.client.Clean()
+	}
+}
+
+func cleanTargets(targets []*Target) {
+	for _, target := range targets {
+		target.clean()
+	}
+}
+
+func findTarget(targets []*Target, name string) *Target {
+	for _, target := range targets {
+		if target.Name == name {
+			return target
+		}
+	}
+	return nil
+}
+
+// registry returns a registry collecting the target, bound to ctx.
+func (t *Target) registry(ctx context.Context) (*prometheus.Registry, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(targetCollector{target: t, ctx: ctx}); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// targetCollector collects a target from the endpoint selected for the scrape
+// bound to ctx.
+type targetCollector struct {
+	target *Target
+	ctx    context.Context
+}
+
+func (c targetCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.target.endpoints[0].exporter.Describe(ch)
+	if len(c.target.endpoints) > 1 {
+		ch <- PoolEndpointActive
+	}
+}
+
+func (c targetCollector) Collect(ch chan<- prometheus.Metric) {
+	active, reachable := c.target.selectEndpoint(c.ctx)
+	c.target.mutex.Lock()
+	c.target.active = active
+	c.target.mutex.Unlock()
+
+	c.target.endpoints[active].exporter.CollectContext(c.ctx, ch)
+	if len(c.target.endpoints) == 1 {
+		return
+	}
+	for i, endpoint := range c.target.endpoints {
+		value := 0.0
+		if reachable && i == active {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(PoolEndpointActive, prometheus.GaugeValue, value, endpoint.address)
+	}
+}
+
+// targetsGatherer collects every target in its own registry, as they share
+// metric descriptors, and adds the target label to named targets. Targets are
+// collected in parallel.
+func targetsGatherer(ctx context.Context, targets []*Target) (prometheus.Gatherer, error) {
+	gatherers := make(parallelGatherers, 0, len(targets))
+	for _, target := range targets {
+		registry, err := target.registry(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(target.Name) == 0 {
+			gatherers = append(gatherers, registry)
+			continue
+		}
+		gatherers = append(gatherers, targetLabelGatherer{gatherer: registry, target: target.Name})
+	}
+	return gatherers, nil
+}
+
+// parallelGatherers gathers all gatherers concurrently, so that a slow or
+// unreachable target does not hold up the others, and merges the results like
+// prometheus.Gatherers.
+type parallelGatherers []prometheus.Gatherer
+
+func (gs parallelGatherers) Gather() ([]*dto.MetricFamily, error) {
+	type result struct {
+		metricFamilies []*dto.MetricFamily
+		err            error
+	}
+	results := make([]result, len(gs))
+	var wg sync.WaitGroup
+	for i, g := range gs {
+		wg.Add(1)
+		go func(i int, g prometheus.Gatherer) {
+			defer wg.Done()
+			metricFamilies, err := g.Gather()
+			results[i] = result{metricFamilies: metricFamilies, err: err}
+		}(i, g)
+	}
+	wg.Wait()
+	gathered := make(prometheus.Gatherers, 0, len(results))
+	for _, r := range results {
+		r := r
+		gathered = append(gathered, prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return r.metricFamilies, r.err
+		}))
+	}
+	return gathered.Gather()
+}
+
+// targetLabelGatherer adds a target label to all metrics of the wrapped
+// gatherer. A target label the metric already has is kept as
+// exported_target, like Prometheus does on a label conflict.
+type targetLabelGatherer struct {
+	gatherer prometheus.Gatherer
+	target   string
+}
+
+func (g targetLabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	metricFamilies, err := g.gatherer.Gather()
+	for _, mf := range metricFamilies {
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.Label {
+				if l.GetName() == "target" {
+					l.Name = proto.String("exported_target")
+				}
+			}
+			m.Label = append(m.Label, &dto.LabelPair{
+				Name:  proto.String("target"),
+				Value: proto.String(g.target),
+			})
+			sort.Slice(m.Label, func(i, j int) bool {
+				return m.Label[i].GetName() < m.Label[j].GetName()
+			})
+		}
+	}
+	return metricFamilies, err
+}
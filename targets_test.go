@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/navcanada/pgpool2-exporter/pgpool2"
+)
+
+func TestTargetConfigEndpointOptions(t *testing.T) {
+	defaults := pgpool2.Options{Hostname: "127.0.0.1", Port: 9898, Username: "pcpadmin"}
+	type endpoint struct {
+		host string
+		port int
+	}
+	tests := []struct {
+		name    string
+		config  TargetConfig
+		want    []endpoint
+		invalid bool
+	}{
+		{name: "defaults", want: []endpoint{{"127.0.0.1", 9898}}},
+		{name: "host and port", config: TargetConfig{Host: "pgpool-a", Port: 9999}, want: []endpoint{{"pgpool-a", 9999}}},
+		{name: "host", config: TargetConfig{Endpoints: []string{"pgpool-a"}}, want: []endpoint{{"pgpool-a", 9898}}},
+		{name: "host:port", config: TargetConfig{Endpoints: []string{"pgpool-a:9999"}}, want: []endpoint{{"pgpool-a", 9999}}},
+		{name: "port of the target", config: TargetConfig{Port: 9000, Endpoints: []string{"pgpool-a", "pgpool-b:9999"}}, want: []endpoint{{"pgpool-a", 9000}, {"pgpool-b", 9999}}},
+		{name: "IPv6 with port", config: TargetConfig{Endpoints: []string{"[::1]:9898"}}, want: []endpoint{{"::1", 9898}}},
+		{name: "IPv6", config: TargetConfig{Endpoints: []string{"2001:db8::1"}}, want: []endpoint{{"2001:db8::1", 9898}}},
+		{name: "bad port", config: TargetConfig{Endpoints: []string{"pgpool-a", "pgpool-b:pcp"}}, invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := tt.config.EndpointOptions(defaults)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("got %v, want an error", options)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []endpoint
+			for _, o := range options {
+				if o.Username != "pcpadmin" {
+					t.Errorf("endpoint %s has username %q, want pcpadmin", o.Hostname, o.Username)
+				}
+				got = append(got, endpoint{o.Hostname, o.Port})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// fakePCP answers pcp_node_count and pcp_proc_count unless it is down, and
+// fails every other command.
+type fakePCP struct {
+	mutex sync.Mutex
+	down  bool
+	// commands run, by command
+	commands map[string]int
+}
+
+func (f *fakePCP) Exec(ctx context.Context, parse func(io.Reader) error, cmd string, args []string, env []string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.commands[cmd]++
+	if f.down {
+		return errors.New("connection refused")
+	}
+	switch cmd {
+	case pgpool2.PCPNodeCount:
+		return parse(strings.NewReader("2\n"))
+	case pgpool2.PCPProcCount:
+		return parse(strings.NewReader("101 102\n"))
+	}
+	return errors.New("not faked")
+}
+
+func (f *fakePCP) setDown(down bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.down = down
+}
+
+func (f *fakePCP) runs(cmd string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.commands[cmd]
+}
+
+// newFakeTarget returns a target with an endpoint on each of the hosts, whose
+// commands the fake of the host runs.
+func newFakeTarget(t *testing.T, fakes map[string]*fakePCP, exporterOptions ExporterOptions, hosts ...string) *Target {
+	t.Helper()
+	options := make([]pgpool2.Options, 0, len(hosts))
+	for _, host := range hosts {
+		options = append(options, pgpool2.Options{Hostname: host, Port: 9898, Username: "pcpadmin", Password: "secret"})
+	}
+	target, err := newTarget("cluster", options, exporterOptions, func(hostname string) (pgpool2.Executor, error) {
+		return fakes[hostname], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(target.clean)
+	return target
+}
+
+// scrapeTarget collects the target once and returns the values of the metric
+// by the value of its label, e.g. pgpool2_pcp_endpoint_active by endpoint.
+func scrapeTarget(t *testing.T, target *Target, metric, label string) map[string]float64 {
+	t.Helper()
+	registry, err := target.registry(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricFamilies, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, mf := range metricFamilies {
+		if mf.GetName() != metric {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label {
+					values[l.GetValue()] = m.GetGauge().GetValue() + m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return values
+}
+
+func TestTargetFailover(t *testing.T) {
+	fakes := map[string]*fakePCP{
+		"pgpool-a": {commands: make(map[string]int)},
+		"pgpool-b": {commands: make(map[string]int)},
+	}
+	target := newFakeTarget(t, fakes, ExporterOptions{}, "pgpool-a", "pgpool-b")
+	tests := []struct {
+		name   string
+		down   []string
+		active map[string]float64
+	}{
+		{name: "preferred endpoint", active: map[string]float64{"pgpool-a:9898": 1, "pgpool-b:9898": 0}},
+		{name: "failover", down: []string{"pgpool-a"}, active: map[string]float64{"pgpool-a:9898": 0, "pgpool-b:9898": 1}},
+		{name: "back to the preferred endpoint", active: map[string]float64{"pgpool-a:9898": 1, "pgpool-b:9898": 0}},
+		{name: "all down", down: []string{"pgpool-a", "pgpool-b"}, active: map[string]float64{"pgpool-a:9898": 0, "pgpool-b:9898": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for host, fake := range fakes {
+				fake.setDown(false)
+				for _, down := range tt.down {
+					if host == down {
+						fake.setDown(true)
+					}
+				}
+			}
+			active := scrapeTarget(t, target, "pgpool2_pcp_endpoint_active", "endpoint")
+			for endpoint, want := range tt.active {
+				if active[endpoint] != want {
+					t.Errorf("endpoint %s is active %v, want %v", endpoint, active[endpoint], want)
+				}
+			}
+		})
+	}
+}
+
+func TestTargetSharesStateAcrossEndpoints(t *testing.T) {
+	fakes := map[string]*fakePCP{
+		"pgpool-a": {commands: make(map[string]int)},
+		"pgpool-b": {commands: make(map[string]int)},
+	}
+	target := newFakeTarget(t, fakes, ExporterOptions{CacheTTL: time.Hour}, "pgpool-a", "pgpool-b")
+	if target.endpoints[0].exporter.pgpool == target.endpoints[1].exporter.pgpool {
+		t.Fatal("the endpoints share a PCP client")
+	}
+
+	scrapeTarget(t, target, "pgpool2_exporter_cache_hits_total", "collector")
+	fakes["pgpool-a"].setDown(true)
+	hits := scrapeTarget(t, target, "pgpool2_exporter_cache_hits_total", "collector")
+	// what pgpool-a collected is served after the failover
+	if runs := fakes["pgpool-b"].runs(pgpool2.PCPProcCount); runs != 0 {
+		t.Errorf("pgpool-b ran pcp_proc_count %d times, want 0", runs)
+	}
+	if hits["proc_count"] != 1 {
+		t.Errorf("got %v cache hits of proc_count, want 1", hits["proc_count"])
+	}
+}
+
+func TestTargetLabelGatherer(t *testing.T) {
+	input := `# TYPE pgpool2_up gauge
+pgpool2_up 1
+# TYPE site_check gauge
+site_check{target="db"} 1
+`
+	metricFamilies, err := targetLabelGatherer{gatherer: textGatherer(t, input), target: "cluster"}.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE pgpool2_up gauge
+pgpool2_up{target="cluster"} 1
+# TYPE site_check gauge
+site_check{exported_target="db",target="cluster"} 1
+`
+	if got := exposition(t, metricFamilies); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
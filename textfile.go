@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+// textfileGatherer reads the metrics in the *.prom files of a directory, so
+// jobs like online recovery scripts can publish their status through the
+// exporter. Files must be written atomically, e.g. by renaming a temporary
+// file into place.
+type textfileGatherer struct {
+	directory string
+}
+
+func (g textfileGatherer) Gather() ([]*dto.MetricFamily, error) {
+	var gatherers prometheus.Gatherers
+	scrapeError := 0.0
+	mtimes := &dto.MetricFamily{
+		Name: proto.String("pgpool2_textfile_mtime_seconds"),
+		Help: proto.String("Modification time of the textfiles read, in seconds since the epoch."),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	files, err := os.ReadDir(g.directory)
+	if err != nil {
+		logrus.Warnf("Cannot read textfile directory: %v", err)
+		scrapeError = 1
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".prom") {
+			continue
+		}
+		path := filepath.Join(g.directory, file.Name())
+		metricFamilies, modTime, err := readTextfile(path)
+		if err != nil {
+			logrus.Warnf("Cannot read textfile %s: %v", path, err)
+			scrapeError = 1
+			continue
+		}
+		gatherers = append(gatherers, prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return metricFamilies, nil
+		}))
+		mtimes.Metric = append(mtimes.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{{Name: proto.String("file"), Value: proto.String(file.Name())}},
+			Gauge: &dto.Gauge{Value: proto.Float64(float64(modTime) / 1e9)},
+		})
+	}
+	status := []*dto.MetricFamily{
+		gaugeFamily("pgpool2_textfile_scrape_error", "1 if reading a textfile failed, 0 otherwise.", nil, scrapeError),
+	}
+	if len(mtimes.Metric) != 0 {
+		status = append(status, mtimes)
+	}
+	gatherers = append(gatherers, prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return status, nil
+	}))
+	return gatherers.Gather()
+}
+
+// readTextfile parses one textfile and returns its modification time in
+// nanoseconds since the epoch.
+func readTextfile(path string) ([]*dto.MetricFamily, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	metricFamilies, err := parseTextMetrics(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	return metricFamilies, info.ModTime().UnixNano(), nil
+}
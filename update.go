@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultReleasesURL = "https://api.github.com/repos/navcanada/pgpool2-exporter/releases/latest"
+	updateCheckTimeout = 30 * time.Second
+)
+
+var (
+	ExporterUpdateAvailable = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "update_available"),
+		"Whether a release newer than the running exporter is published (1) or not (0), by running and latest version",
+		[]string{"version", "latest_version"}, nil,
+	)
+	ExporterUpdateCheckSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "update_check_success"),
+		"Whether the last check for a new release succeeded",
+		nil, nil,
+	)
+)
+
+// UpdateChecker looks up the latest release at an interval. It only reports
+// it, the exporter never updates itself.
+type UpdateChecker struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+
+	mutex   sync.Mutex
+	checked bool
+	success bool
+	latest  string
+}
+
+func NewUpdateChecker(url string, interval time.Duration) *UpdateChecker {
+	return &UpdateChecker{
+		url:      url,
+		interval: interval,
+		client:   &http.Client{Timeout: updateCheckTimeout},
+	}
+}
+
+func (c *UpdateChecker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ExporterUpdateAvailable
+	ch <- ExporterUpdateCheckSuccess
+}
+
+func (c *UpdateChecker) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.checked {
+		return
+	}
+	success := 0.0
+	if c.success {
+		success = 1
+	}
+	ch <- prometheus.MustNewConstMetric(ExporterUpdateCheckSuccess, prometheus.GaugeValue, success)
+	if len(c.latest) == 0 {
+		return
+	}
+	available := 0.0
+	if newerVersion(c.latest, version.Version) {
+		available = 1
+	}
+	ch <- prometheus.MustNewConstMetric(ExporterUpdateAvailable, prometheus.GaugeValue, available, version.Version, c.latest)
+}
+
+// Run checks for a new release forever, the first time right away.
+func (c *UpdateChecker) Run() {
+	for {
+		latest, err := c.latestVersion()
+		c.mutex.Lock()
+		c.checked = true
+		c.success = err == nil
+		if err == nil {
+			c.latest = latest
+		}
+		c.mutex.Unlock()
+		if err != nil {
+			logrus.Warnf("Cannot check for a new release: %v", err)
+		} else if newerVersion(latest, version.Version) {
+			logrus.Infof("A new release is available: %s (running %s)", latest, version.Version)
+		}
+		time.Sleep(c.interval)
+	}
+}
+
+// latestVersion returns the version of the latest release, without the v
+// prefix of the tag.
+func (c *UpdateChecker) latestVersion() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), updateCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", exporterName+"/"+version.Version)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&release); err != nil {
+		return "", err
+	}
+	latest := strings.TrimPrefix(release.TagName, "v")
+	if _, ok := parseVersion(latest); !ok {
+		return "", fmt.Errorf("unexpected release tag %q", release.TagName)
+	}
+	return latest, nil
+}
+
+// newerVersion reports whether the version a is newer than b. Versions that
+// do not parse, like those of development builds, are never newer.
+func newerVersion(a, b string) bool {
+	va, okA := parseVersion(a)
+	vb, okB := parseVersion(b)
+	if !okA || !okB {
+		return false
+	}
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] > vb[i]
+		}
+	}
+	return false
+}
+
+// parseVersion parses major.minor.patch, ignoring a pre-release or build
+// suffix.
+func parseVersion(v string) ([3]int, bool) {
+	var parsed [3]int
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return parsed, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// cidrListFlag collects comma separated networks, bare addresses are single
+// hosts.
+type cidrListFlag []*net.IPNet
+
+func (f *cidrListFlag) String() string {
+	networks := make([]string, 0, len(*f))
+	for _, network := range *f {
+		networks = append(networks, network.String())
+	}
+	return strings.Join(networks, ",")
+}
+
+func (f *cidrListFlag) Set(value string) error {
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if len(cidr) == 0 {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return fmt.Errorf("invalid address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			*f = append(*f, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		*f = append(*f, network)
+	}
+	return nil
+}
+
+func (f cidrListFlag) contains(ip net.IP) bool {
+	for _, network := range f {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client of a request. Behind trusted
+// proxies it is the last address in X-Forwarded-For that is no trusted proxy.
+func clientIP(r *http.Request, trustedProxies cidrListFlag) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !trustedProxies.contains(ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		forwardedIP := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if forwardedIP == nil {
+			break
+		}
+		ip = forwardedIP
+		if !trustedProxies.contains(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// statusRecorder keeps the status code and size of a response for logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// accessHandler counts the requests to mux by client and handler, and logs
+// them if accessLog is set, so a client that scrapes too often can be found.
+// Clients outside allowedClients are denied, unless it is empty.
+type accessHandler struct {
+	mux            *http.ServeMux
+	trustedProxies cidrListFlag
+	allowedClients cidrListFlag
+	accessLog      bool
+	requests       *prometheus.CounterVec
+}
+
+func newAccessHandler(mux *http.ServeMux, trustedProxies, allowedClients cidrListFlag, accessLog bool) *accessHandler {
+	return &accessHandler{
+		mux:            mux,
+		trustedProxies: trustedProxies,
+		allowedClients: allowedClients,
+		accessLog:      accessLog,
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: "exporter",
+				Name:      "http_requests_total",
+				Help:      "Number of HTTP requests by client address and handler",
+			},
+			[]string{"client", "handler"},
+		),
+	}
+}
+
+func (h *accessHandler) Describe(ch chan<- *prometheus.Desc) {
+	h.requests.Describe(ch)
+}
+
+func (h *accessHandler) Collect(ch chan<- prometheus.Metric) {
+	h.requests.Collect(ch)
+}
+
+func (h *accessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ip := clientIP(r, h.trustedProxies)
+	client := ip.String()
+	// the pattern, not the path, so that random paths do not add series
+	_, pattern := h.mux.Handler(r)
+	h.requests.WithLabelValues(client, pattern).Inc()
+	var handler http.Handler = h.mux
+	if len(h.allowedClients) != 0 && (ip == nil || !h.allowedClients.contains(ip)) {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "client address not allowed", http.StatusForbidden)
+		})
+	}
+	if !h.accessLog {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	recorder := &statusRecorder{ResponseWriter: w}
+	handler.ServeHTTP(recorder, r)
+	logrus.WithFields(logrus.Fields{
+		"client":   client,
+		"method":   r.Method,
+		"path":     r.URL.Path,
+		"status":   recorder.status,
+		"bytes":    recorder.bytes,
+		"duration": time.Since(start).Seconds(),
+	}).Info("HTTP request")
+}
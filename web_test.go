@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// cidrList parses networks like -web.allow-cidr.
+func cidrList(tb testing.TB, value string) cidrListFlag {
+	tb.Helper()
+	var networks cidrListFlag
+	if err := networks.Set(value); err != nil {
+		tb.Fatal(err)
+	}
+	return networks
+}
+
+func TestCIDRListFlag(t *testing.T) {
+	tests := []struct {
+		value    string
+		want     string
+		contains []string
+		excludes []string
+		invalid  bool
+	}{
+		{value: "10.20.0.0/16", want: "10.20.0.0/16", contains: []string{"10.20.3.4"}, excludes: []string{"10.21.0.1"}},
+		{value: "192.0.2.5", want: "192.0.2.5/32", contains: []string{"192.0.2.5"}, excludes: []string{"192.0.2.6"}},
+		{value: "10.0.0.0/8, 2001:db8::/32", want: "10.0.0.0/8,2001:db8::/32", contains: []string{"10.1.1.1", "2001:db8::1"}, excludes: []string{"2001:db9::1"}},
+		{value: "::1", want: "::1/128", contains: []string{"::1"}, excludes: []string{"127.0.0.1"}},
+		{value: "10.0.0.0/8,", want: "10.0.0.0/8"},
+		{value: "10.0.0.0/33", invalid: true},
+		{value: "pgpool-a", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var networks cidrListFlag
+			err := networks.Set(tt.value)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("got %s, want an error", networks.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if networks.String() != tt.want {
+				t.Errorf("got %s, want %s", networks.String(), tt.want)
+			}
+			for _, ip := range tt.contains {
+				if !networks.contains(net.ParseIP(ip)) {
+					t.Errorf("%s is not contained", ip)
+				}
+			}
+			for _, ip := range tt.excludes {
+				if networks.contains(net.ParseIP(ip)) {
+					t.Errorf("%s is contained", ip)
+				}
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := cidrList(t, "10.0.0.0/8")
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{name: "direct", remoteAddr: "192.0.2.5:41000", want: "192.0.2.5"},
+		{name: "forwarded by untrusted client", remoteAddr: "192.0.2.5:41000", forwarded: []string{"198.51.100.1"}, want: "192.0.2.5"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:41000", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "proxy chain", remoteAddr: "10.0.0.1:41000", forwarded: []string{"198.51.100.9, 198.51.100.1, 10.0.0.2"}, want: "198.51.100.1"},
+		{name: "several headers", remoteAddr: "10.0.0.1:41000", forwarded: []string{"198.51.100.9", "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "garbage", remoteAddr: "10.0.0.1:41000", forwarded: []string{"unknown"}, want: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(r, proxies); got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessHandlerAllowedClients(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {})
+	handler := newAccessHandler(mux, cidrList(t, "10.0.0.0/8"), cidrList(t, "192.0.2.0/24"), false)
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       int
+	}{
+		{name: "allowed", remoteAddr: "192.0.2.5:41000", want: http.StatusOK},
+		{name: "denied", remoteAddr: "198.51.100.1:41000", want: http.StatusForbidden},
+		{name: "allowed behind proxy", remoteAddr: "10.0.0.1:41000", forwarded: "192.0.2.5", want: http.StatusOK},
+		{name: "proxy itself", remoteAddr: "10.0.0.1:41000", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if len(tt.forwarded) != 0 {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}